@@ -1,15 +1,43 @@
 package frame
 
 import (
+	"github.com/bradfitz/gomemcache/memcache"
 	"github.com/gin-gonic/gin"
+	"net/http"
 	"runtime"
 	"sync"
 )
 
 type app struct {
-	Log    *log
-	server *server
+	Log        *log
+	server     *server
+	serverOnce sync.Once
 	config
+	resource  *resource      //各种连接池
+	watcher   *configWatcher //配置文件监听
+	lifecycle *lifecycle     //启动和关闭钩子
+	commands  *commandSet    //命令行命令
+}
+
+//应用持有的连接池,每个应用独立
+type resource struct {
+	dbGroupCache    map[string]*dbGroup
+	dbWatched       map[string]bool //已注册配置变化回调的连接池,关闭后重新建立时不重复注册
+	dbLock          sync.RWMutex
+	redisGroupCache map[string]*redisGroup
+	redisLock       sync.RWMutex
+	mcCacheMap      map[string]*memcache.Client
+	mcLock          sync.RWMutex
+	httpClientPool  map[string]*http.Client
+	httpLock        sync.RWMutex
+}
+
+//NewApp 的参数
+type AppOptions struct {
+	Environment string //环境变量
+	AppName     string //应用名称
+	EnvPath     string //配置文件目录
+	IncludeEnv  bool   //EnvPath 是否已经包含环境目录
 }
 
 var appObj *app
@@ -21,11 +49,46 @@ func App() *app {
 		return appObj
 	}
 	appOnce.Do(func() {
-		appObj = &app{}
+		appObj = newApp()
 	})
 	return appObj
 }
 
+// 新建一个独立的应用,拥有自己的日志、配置目录和连接池,与 App() 默认应用互不影响
+// 用法:
+//	other := frame.NewApp(&frame.AppOptions{Environment: "develop", AppName: "api", EnvPath: "/data/config"})
+//	other.Redis("redis/main").Get("key")
+// options 为空时使用零值,环境为空会和 Init 一样 panic
+// 以下是进程级别的,所有应用共用:
+//	SetErrorHandle、SetMysqlBeforeExecute 等回调,回调中可以通过 mysql.App 区分应用
+//	命令行参数、RegisterEnvironment 注册的环境
+//	RegisterConfigDecoder、RegisterLogSink、RegisterAlertHandler、RegisterPreflight 注册的扩展
+//	配置中解析出来的密钥(所有应用的日志都会打码)
+//	日志级别的信号 LogLevelSignal(收到后所有应用都重新读取)
+func NewApp(options *AppOptions) *app {
+	if options == nil {
+		options = &AppOptions{}
+	}
+	app := newApp()
+	return app.Init(options.Environment, options.AppName, options.EnvPath, options.IncludeEnv)
+}
+
+func newApp() *app {
+	app := &app{
+		resource: &resource{
+			dbGroupCache:    make(map[string]*dbGroup),
+			dbWatched:       make(map[string]bool),
+			redisGroupCache: make(map[string]*redisGroup),
+			mcCacheMap:      make(map[string]*memcache.Client),
+			httpClientPool:  make(map[string]*http.Client),
+		},
+		watcher: newConfigWatcher(),
+	}
+	app.lifecycle = newLifecycle(app)
+	app.commands = newCommandSet(app)
+	return app
+}
+
 // App方法之后需要初始化的一些信息
 // environment 环境变量
 // appName 应用名称
@@ -37,13 +100,13 @@ func (app *app) Init(environment string, appName string, envPath string, include
 
 	app.setEnv(environment)
 	app.setAppName(appName)
-	app.setEnvPath(envPath,includeEnv...)
+	app.setEnvPath(envPath, includeEnv...)
 	//初始化日志
-	app.Log = getLog()
+	app.Log = app.newLog()
+	app.watchLogLevel()
 	return app
 }
 
-var serverOnce sync.Once
 // 服务器程序,需要web服务器的时候调用返回一个server实例
 // port 监听的端口
 // 服务守护进程id文件
@@ -51,8 +114,9 @@ func (app *app) Server(port int, pidFile ...string) *server {
 	if app.server != nil {
 		return app.server
 	}
-	serverOnce.Do(func() {
+	app.serverOnce.Do(func() {
 		server := &server{
+			app:    app,
 			port:   port,
 			router: make([]func(gin *gin.Engine), 0),
 		}
@@ -66,3 +130,34 @@ func (app *app) Server(port int, pidFile ...string) *server {
 	})
 	return app.server
 }
+
+/**
+获取绑定在该应用上的各种资源
+*/
+
+//数据库
+func (app *app) GetMysql(dbGroup string) *Mysql {
+	return newMysql(app, dbGroup)
+}
+
+//redis
+func (app *app) Redis(groupName string) *Redis {
+	return &Redis{GroupName: groupName, App: app}
+}
+
+//http请求
+func (app *app) Curl(clientGroup ...string) *Curl {
+	curl := &Curl{App: app}
+	if len(clientGroup) > 0 {
+		curl.ClientGroup = clientGroup[0]
+	}
+	return curl
+}
+
+//为空时使用默认应用
+func getApp(app *app) *app {
+	if app == nil {
+		return App()
+	}
+	return app
+}
@@ -1,7 +1,7 @@
 package frame
 
 import (
-	"runtime/debug"
+	"context"
 )
 
 const CacheTypeMc = "mc"
@@ -26,6 +26,15 @@ type CacheTrait struct {
 	Group     string //需要的配置资源,如 redis/main
 	Ttl       int    //默认缓存时长(秒)
 	PreFixKey string //缓存key前缀
+	App       *app   //所属应用,为空使用默认应用
+	ctx       context.Context
+}
+
+//设置上下文,报错日志带上上下文中的字段
+func (cacheTrait *CacheTrait) WithContext(ctx context.Context) *CacheTrait {
+	cacheTrait.ctx = ctx
+	cacheTrait.cache = nil
+	return cacheTrait
 }
 
 func (cacheTrait *CacheTrait) getCache() Cache {
@@ -35,17 +44,20 @@ func (cacheTrait *CacheTrait) getCache() Cache {
 	if cacheTrait.Type == CacheTypeMc {
 		cacheTrait.cache = &mcCache{
 			GroupName: cacheTrait.Group,
+			App:       cacheTrait.App,
+			ctx:       cacheTrait.ctx,
 		}
 	} else if cacheTrait.Type == CacheTypeRedis {
 		cacheTrait.cache = &redisCache{
 			GroupName: cacheTrait.Group,
+			App:       cacheTrait.App,
+			ctx:       cacheTrait.ctx,
 		}
 	} else {
 		msg := map[string]interface{}{
 			"error": CacheError.Error(),
-			"stack": string(debug.Stack()),
 		}
-		App().Log.Error(msg, LogCacheError)
+		contextLog(cacheTrait.App, cacheTrait.ctx).errorStack(msg, LogCacheError)
 		panic(CacheError)
 	}
 	return cacheTrait.cache
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"frame"
+	"os"
+)
+
+// 加密配置值,输出结果可以直接写入配置文件
+// 用法: go run cmd/encrypt/main.go -config-key=密钥 -value=明文
+// 密钥也可以通过环境变量 FRAME_CONFIG_KEY 传入
+func main() {
+	key := frame.GetFlag(frame.ConfigKeyFlag, os.Getenv(frame.ConfigKeyEnv)).(string)
+	value := frame.GetFlag("value", "").(string)
+	if key == "" || value == "" {
+		fmt.Println("用法: encrypt -config-key=密钥 -value=明文")
+		os.Exit(1)
+	}
+	result, err := frame.EncryptConfigValue(value, key)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println(result)
+}
@@ -0,0 +1,247 @@
+package frame
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 命令行命令
+// 内置命令 start stop restart status reload config-check log,其中服务相关的需要先调用 App().Server(port) 创建server
+// 用法:
+//	frame.App().Init("develop", "api", "/data/config")
+//	frame.App().Server(8080).RegisterRoute(route)
+//	frame.App().RegisterCommand(&frame.Command{
+//		Name:  "sync-user",
+//		Usage: "同步用户数据",
+//		Flags: []*frame.Flag{{Name: "day", Default: 1, Usage: "同步最近几天"}},
+//		Run: func(args []string) error {
+//			day := frame.FlagInt("day")
+//			return nil
+//		},
+//	})
+//	if err := frame.App().RunCommand(); err != nil {
+//		fmt.Println(err)
+//		os.Exit(1)
+//	}
+// 运行: ./api start, ./api sync-user -day=3, ./api help, ./api sync-user -help
+// 兼容旧的 -cmd=start 写法
+// 自定义命令执行前会执行启动钩子,执行后执行关闭钩子
+
+type Command struct {
+	Name  string                    //命令名称,如 sync-user
+	Usage string                    //说明,用于帮助输出
+	Flags []*Flag                   //命令的参数,运行时声明
+	Run   func(args []string) error //args 为命令名之后的普通参数
+	raw   bool                      //内置命令,不执行启动和关闭钩子
+}
+
+type commandSet struct {
+	lock     sync.RWMutex
+	commands map[string]*Command
+	names    []string //注册顺序
+}
+
+func newCommandSet(app *app) *commandSet {
+	commandSet := &commandSet{commands: make(map[string]*Command), names: make([]string, 0)}
+	for _, command := range app.builtinCommands() {
+		commandSet.add(command)
+	}
+	return commandSet
+}
+
+func (commandSet *commandSet) add(command *Command) {
+	commandSet.lock.Lock()
+	defer commandSet.lock.Unlock()
+	if _, ok := commandSet.commands[command.Name]; !ok {
+		commandSet.names = append(commandSet.names, command.Name)
+	}
+	commandSet.commands[command.Name] = command
+}
+
+func (commandSet *commandSet) get(name string) (*Command, bool) {
+	commandSet.lock.RLock()
+	defer commandSet.lock.RUnlock()
+	command, ok := commandSet.commands[name]
+	return command, ok
+}
+
+//注册命令,同名会被替换(包括内置命令)
+func (app *app) RegisterCommand(commands ...*Command) {
+	for _, command := range commands {
+		app.commands.add(command)
+	}
+}
+
+//执行命令
+// args 命令名和参数,不传时使用命令行中的普通参数,没有普通参数时读取 -cmd
+func (app *app) RunCommand(args ...string) error {
+	fromFlag := len(args) == 0
+	if fromFlag {
+		args = FlagArgs()
+		if len(args) == 0 {
+			if cmd := FlagString("cmd"); cmd != "" {
+				args = []string{cmd}
+			}
+		}
+	}
+	if len(args) == 0 || args[0] == "help" {
+		if len(args) > 1 {
+			if command, ok := app.commands.get(args[1]); ok {
+				fmt.Print(commandUsage(command))
+				return nil
+			}
+		}
+		fmt.Print(app.CommandUsage())
+		return nil
+	}
+	command, ok := app.commands.get(args[0])
+	if !ok {
+		fmt.Print(app.CommandUsage())
+		return errors.New(CommandError.Error() + ":" + args[0])
+	}
+	DefineFlag(command.Flags...)
+	if fromFlag && len(FlagArgs()) > 0 {
+		//声明布尔参数后重新解析,普通参数可能变化
+		args = FlagArgs()
+	}
+	if FlagBool("help") || FlagBool("h") {
+		fmt.Print(commandUsage(command))
+		return nil
+	}
+	if err := ParseFlags(); err != nil {
+		return err
+	}
+	if command.raw {
+		return command.Run(args[1:])
+	}
+	if err := app.Start(); err != nil {
+		_ = app.Shutdown()
+		return err
+	}
+	err := command.Run(args[1:])
+	if shutdownErr := app.Shutdown(); err == nil {
+		err = shutdownErr
+	}
+	return err
+}
+
+//所有命令的帮助信息
+func (app *app) CommandUsage() string {
+	app.commands.lock.RLock()
+	commands := make([]*Command, 0, len(app.commands.names))
+	for _, name := range app.commands.names {
+		commands = append(commands, app.commands.commands[name])
+	}
+	app.commands.lock.RUnlock()
+	width := 0
+	for _, command := range commands {
+		if len(command.Name) > width {
+			width = len(command.Name)
+		}
+	}
+	usage := "用法: " + filepath.Base(os.Args[0]) + " <命令> [参数]\n命令:\n"
+	for _, command := range commands {
+		usage += "  " + command.Name + strings.Repeat(" ", width-len(command.Name)+2) + command.Usage + "\n"
+	}
+	usage += "使用 help <命令> 或者 <命令> -help 查看命令参数\n"
+	return usage
+}
+
+//单个命令的帮助信息
+func commandUsage(command *Command) string {
+	usage := "用法: " + filepath.Base(os.Args[0]) + " " + command.Name + " [参数]\n"
+	if command.Usage != "" {
+		usage += command.Usage + "\n"
+	}
+	if len(command.Flags) > 0 {
+		usage += "参数:\n" + flagUsage(command.Flags)
+	}
+	return usage
+}
+
+//内置命令
+func (app *app) builtinCommands() []*Command {
+	return []*Command{
+		{Name: "start", Usage: "启动服务", raw: true, Run: func(args []string) error {
+			server, err := app.commandServer()
+			if err != nil {
+				return err
+			}
+			server.Start()
+			return nil
+		}},
+		{Name: "stop", Usage: "停止服务", raw: true, Run: func(args []string) error {
+			server, err := app.commandServer()
+			if err != nil {
+				return err
+			}
+			server.Stop()
+			return nil
+		}},
+		{Name: "restart", Usage: "重启服务", raw: true, Run: func(args []string) error {
+			server, err := app.commandServer()
+			if err != nil {
+				return err
+			}
+			server.Restart()
+			return nil
+		}},
+		{Name: "status", Usage: "查看服务运行状态", raw: true, Run: func(args []string) error {
+			server, err := app.commandServer()
+			if err != nil {
+				return err
+			}
+			pid, running := server.Status()
+			if !running {
+				fmt.Println("服务未运行")
+				return ServerNotRunningError
+			}
+			fmt.Printf("服务运行中,进程id:%d\n", pid)
+			return nil
+		}},
+		{Name: "reload", Usage: "通知运行中的服务重新加载配置文件", raw: true, Run: func(args []string) error {
+			server, err := app.commandServer()
+			if err != nil {
+				return err
+			}
+			if err := server.Reload(); err != nil {
+				return err
+			}
+			fmt.Println("已发送重新加载信号")
+			return nil
+		}},
+		{Name: "config-check", Usage: "检查配置文件,参数为需要检查的目录,如 db redis", raw: true, Run: func(args []string) error {
+			if err := app.Preflight(args...); err != nil {
+				fmt.Println(err.Error())
+				return err
+			}
+			fmt.Println("配置检查通过")
+			return nil
+		}},
+		{Name: "log", Usage: "查询日志,-follow 持续输出新日志", raw: true, Run: app.logCommand, Flags: []*Flag{
+			{Name: "since", Default: time.Hour, Usage: "查询最近多长时间,如 30m"},
+			{Name: "from", Default: "", Usage: "开始时间,如 2023-01-11 03:00,优先于since"},
+			{Name: "to", Default: "", Usage: "结束时间,默认当前时间"},
+			{Name: "level", Default: "", Usage: "级别,多个用逗号分隔,默认 debug,info,warn,error"},
+			{Name: "type", Default: "", Usage: "日志类型,多个用逗号分隔"},
+			{Name: "pid", Default: 0, Usage: "进程id"},
+			{Name: "requestId", Default: "", Usage: "请求id"},
+			{Name: "field", Default: "", Usage: "字段匹配 key=value,可以重复传入", Repeated: true},
+			{Name: "limit", Default: 0, Usage: "最多输出的条数,0不限制"},
+			{Name: "raw", Default: false, Usage: "输出原始内容"},
+			{Name: "follow", Default: false, Usage: "输出历史后继续输出新日志"},
+		}},
+	}
+}
+
+func (app *app) commandServer() (*server, error) {
+	if app.server == nil {
+		return nil, ServerNotCreatedError
+	}
+	return app.server, nil
+}
@@ -1,6 +1,7 @@
 package frame
 
 import (
+	"fmt"
 	"github.com/BurntSushi/toml"
 	"runtime/debug"
 	"sync"
@@ -13,6 +14,8 @@ type config struct {
 	AppName     string //应用名称
 	Environment string //环境变量
 	EnvPath     string //配置文件路径(区分环境)
+	BasePath    string //公共配置文件路径(不区分环境),与环境配置合并,环境配置优先
+	envRoot     string //配置文件根目录(不包含环境),用于查找回退环境的配置
 }
 
 const EnvBeta = "beta"
@@ -21,7 +24,7 @@ const EnvPre = "pre"
 const EnvDevelop = "develop"
 
 func (config *config) setEnv(env string) {
-	if env != EnvBeta && env != EnvProduct && env != EnvPre && env != EnvDevelop {
+	if _, ok := GetEnvironment(env); !ok {
 		panic(EnvironmentError)
 	}
 	config.Environment = env
@@ -71,36 +74,114 @@ func (config *config) setEnvPath(path string, includeEnv ...bool) {
 		config.EnvPath = path
 	} else {
 		config.EnvPath = path + "/" + config.Environment
+		config.BasePath = path + "/" + ConfigLayerBase
+		config.envRoot = path
 	}
 }
 
+//设置公共配置文件路径,为空则不使用公共配置
+func (config *config) SetBasePath(path string) {
+	config.BasePath = path
+}
+
 //读取配置文件内容
 // configFile 配置文件路径，如redis/main
 // configStruct 需要读取的结构体
-func (config *config) Env(configFile string, configStruct interface{}) error {
-	filePath := App().EnvPath + "/" + configFile
-	return parseConf(filePath, configStruct)
+func (app *app) Env(configFile string, configStruct interface{}) error {
+	_, err := app.parseEnv(configFile, configStruct, false)
+	return err
+}
+
+//读取配置文件内容,并返回每个字段来自哪一层
+// 返回 字段路径(如 master.host) => 来源,来源为 base(公共配置)、环境名称、env:环境变量名 或者 flag:命令行参数名
+func (app *app) EnvSource(configFile string, configStruct interface{}) (map[string]string, error) {
+	return app.parseEnv(configFile, configStruct, true)
+}
+
+func (app *app) parseEnv(configFile string, configStruct interface{}, withSource bool) (map[string]string, error) {
+	sources, err := parseConf(app.configLayers(configFile), configStruct, withSource)
+	if err != nil {
+		app.configError(err)
+		return sources, err
+	}
+	//环境变量和命令行参数覆盖
+	overrides, err := configOverride(configFile, configStruct)
+	if err != nil {
+		app.configError(err)
+		return sources, err
+	}
+	for k, v := range overrides {
+		sources[k] = v
+	}
+	//解析密钥引用
+	err = resolveConfigSecret(configFile, configStruct)
+	if err != nil {
+		app.configError(err)
+		return sources, err
+	}
+	//根据validate标签校验
+	err = validateConfig(configFile, configStruct)
+	if err != nil {
+		app.configError(err)
+	}
+	return sources, err
+}
+
+//配置文件对应的实际文件路径,用于监听文件变化
+func (config *config) configFilePaths(configFile string) []string {
+	result := make([]string, 0)
+	for _, layer := range config.configLayers(configFile) {
+		for _, ext := range configExts() {
+			result = append(result, layer.path+"."+ext)
+		}
+	}
+	return result
 }
 
 /**
-从toml配置文件读取配置
+从配置文件读取配置,支持toml,yaml,json
 */
 var lock sync.RWMutex
 
-func parseConf(filePath string, configStruct interface{}) error {
+func parseConf(layers []*configLayer, configStruct interface{}, withSource bool) (map[string]string, error) {
 	lock.RLock()
 	defer lock.RUnlock()
 
-	if filePath == "" {
-		return ConfigFileError
+	sources := make(map[string]string)
+	if len(layers) == 0 {
+		return sources, ConfigFileError
 	}
-	_, err := toml.DecodeFile(filePath+".toml", configStruct)
-	if err != nil {
-		msg := map[string]interface{}{
-			"error": err.Error(),
-			"stack": string(debug.Stack()),
+	existLayers := make([]*configLayer, 0)
+	for _, layer := range layers {
+		if layer.exists() {
+			existLayers = append(existLayers, layer)
 		}
-		App().Log.Error(msg, LogConfigError)
 	}
-	return err
+	var err error
+	if len(existLayers) == 0 {
+		_, err = toml.DecodeFile(layers[len(layers)-1].path+".toml", configStruct)
+	} else if filePath, ext, _ := existLayers[0].file(); len(existLayers) == 1 && ext == "toml" && !withSource {
+		//只有一层toml配置直接读取
+		_, err = toml.DecodeFile(filePath, configStruct)
+	} else {
+		var data map[string]interface{}
+		data, err = loadConfLayers(existLayers, sources)
+		if err == nil {
+			err = decodeConfMap(data, configStruct)
+		}
+	}
+	return sources, err
+}
+
+//记录配置错误,日志初始化之前直接输出
+func (app *app) configError(err error) {
+	msg := map[string]interface{}{
+		"error": err.Error(),
+	}
+	if app.Log == nil {
+		msg["stack"] = string(debug.Stack())
+		fmt.Println(msg)
+		return
+	}
+	app.Log.errorStack(msg, LogConfigError)
 }
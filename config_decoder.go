@@ -0,0 +1,144 @@
+package frame
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+	"sync"
+)
+
+// 配置文件格式
+// 根据扩展名选择解析方法,App().Env("redis/main", &cfg) 依次查找 main.toml main.yaml main.yml main.json
+// 所有格式都读取到同一个带toml标签的结构体中
+// 可以通过 frame.RegisterConfigDecoder 注册新的格式
+
+//解析配置文件内容
+type ConfigDecoder func(data []byte) (map[string]interface{}, error)
+
+type configDecoderRegistry struct {
+	lock     sync.RWMutex
+	exts     []string //查找顺序
+	decoders map[string]ConfigDecoder
+}
+
+var configDecoders = &configDecoderRegistry{
+	exts:     make([]string, 0),
+	decoders: make(map[string]ConfigDecoder),
+}
+
+func init() {
+	RegisterConfigDecoder("toml", decodeToml)
+	RegisterConfigDecoder("yaml", decodeYaml)
+	RegisterConfigDecoder("yml", decodeYaml)
+	RegisterConfigDecoder("json", decodeJson)
+}
+
+//注册配置文件格式,已存在的扩展名会被替换
+// ext 扩展名,不包含点,如 yaml
+func RegisterConfigDecoder(ext string, decoder ConfigDecoder) {
+	configDecoders.lock.Lock()
+	defer configDecoders.lock.Unlock()
+	if _, ok := configDecoders.decoders[ext]; !ok {
+		configDecoders.exts = append(configDecoders.exts, ext)
+	}
+	configDecoders.decoders[ext] = decoder
+}
+
+//已注册的扩展名,按查找顺序
+func configExts() []string {
+	configDecoders.lock.RLock()
+	defer configDecoders.lock.RUnlock()
+	exts := make([]string, len(configDecoders.exts))
+	copy(exts, configDecoders.exts)
+	return exts
+}
+
+func getConfigDecoder(ext string) (ConfigDecoder, bool) {
+	configDecoders.lock.RLock()
+	defer configDecoders.lock.RUnlock()
+	decoder, ok := configDecoders.decoders[ext]
+	return decoder, ok
+}
+
+func decodeToml(data []byte) (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+	_, err := toml.Decode(string(data), &result)
+	return result, err
+}
+
+func decodeYaml(data []byte) (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+	if err := yaml.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	return normalizeConfMap(result)
+}
+
+func decodeJson(data []byte) (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&result); err != nil {
+		return nil, err
+	}
+	return normalizeConfMap(result)
+}
+
+//统一成toml可以编码的类型
+func normalizeConfMap(data map[string]interface{}) (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+	for k, v := range data {
+		value, err := normalizeConfValue(v)
+		if err != nil {
+			return nil, errors.New(k + ":" + err.Error())
+		}
+		//toml没有null,未设置的字段跳过
+		if value != nil {
+			result[k] = value
+		}
+	}
+	return result, nil
+}
+
+func normalizeConfValue(v interface{}) (interface{}, error) {
+	switch v.(type) {
+	case json.Number:
+		if i, err := v.(json.Number).Int64(); err == nil {
+			return i, nil
+		}
+		return v.(json.Number).Float64()
+	case int:
+		return int64(v.(int)), nil
+	case map[string]interface{}:
+		return normalizeConfMap(v.(map[string]interface{}))
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{})
+		for key, value := range v.(map[interface{}]interface{}) {
+			m[convertToString(key)] = value
+		}
+		return normalizeConfMap(m)
+	case []interface{}:
+		arr := v.([]interface{})
+		items := make([]interface{}, 0)
+		tables := make([]map[string]interface{}, 0)
+		for _, item := range arr {
+			value, err := normalizeConfValue(item)
+			if err != nil {
+				return nil, err
+			}
+			if table, ok := value.(map[string]interface{}); ok {
+				tables = append(tables, table)
+			}
+			items = append(items, value)
+		}
+		//数组中都是表的,转换成toml的表数组
+		if len(arr) > 0 && len(tables) == len(arr) {
+			return tables, nil
+		}
+		return items, nil
+	default:
+		return v, nil
+	}
+}
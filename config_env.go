@@ -0,0 +1,140 @@
+package frame
+
+import (
+	"sync"
+)
+
+// 环境注册
+// 默认注册了 beta product pre develop 四个环境,其他环境需要在 App().Init 之前注册
+// 用法:
+//	frame.RegisterEnvironment(&frame.Environment{
+//		Name:     "staging-eu",
+//		Tier:     frame.EnvTierProduction,
+//		Fallback: []string{"pre"},
+//	})
+// Fallback 为回退链,读取配置时回退环境的配置文件作为下层与本环境的配置合并,
+// 本环境没有的文件或字段使用回退环境的,回退环境自己的回退链也会继续查找
+// 回退环境需要先注册,不能形成循环;环境名称不能是公共配置目录名 base
+
+//环境等级
+const EnvTierProduction = "production"        //生产或类生产环境
+const EnvTierNonProduction = "non-production" //非生产环境
+
+type Environment struct {
+	Name     string   //环境名称,同时是配置文件目录名
+	Tier     string   //环境等级
+	Fallback []string //回退环境,越靠前优先级越高
+}
+
+var environments = map[string]*Environment{
+	EnvBeta:    {Name: EnvBeta, Tier: EnvTierNonProduction},
+	EnvProduct: {Name: EnvProduct, Tier: EnvTierProduction},
+	EnvPre:     {Name: EnvPre, Tier: EnvTierProduction},
+	EnvDevelop: {Name: EnvDevelop, Tier: EnvTierNonProduction},
+}
+var environmentLock sync.RWMutex
+
+//注册环境,同名环境会被替换,保存的是副本,注册后修改 env 不影响已注册的环境
+// 名称为空或为 base、等级错误、回退环境未注册或形成循环时 panic
+func RegisterEnvironment(env *Environment) {
+	if env == nil || env.Name == "" {
+		panic(EnvironmentError)
+	}
+	if env.Name == ConfigLayerBase {
+		panic(EnvironmentError.Error() + ":" + env.Name + " 是公共配置目录")
+	}
+	registered := env.copy()
+	switch registered.Tier {
+	case "":
+		registered.Tier = EnvTierNonProduction
+	case EnvTierProduction, EnvTierNonProduction:
+	default:
+		panic(EnvironmentError.Error() + ":" + env.Name + " 的等级 " + env.Tier + " 不存在")
+	}
+	environmentLock.Lock()
+	defer environmentLock.Unlock()
+	for _, fallback := range registered.Fallback {
+		if _, ok := environments[fallback]; !ok {
+			panic(EnvironmentError.Error() + ":" + env.Name + " 的回退环境 " + fallback + " 未注册")
+		}
+	}
+	if fallbackCycle(registered) {
+		panic(EnvironmentError.Error() + ":" + env.Name + " 的回退链形成循环")
+	}
+	environments[registered.Name] = registered
+}
+
+//替换为 env 之后,从 env 的回退链能否回到 env
+func fallbackCycle(env *Environment) bool {
+	visited := make(map[string]bool)
+	queue := append([]string(nil), env.Fallback...)
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if name == env.Name {
+			return true
+		}
+		if visited[name] {
+			continue
+		}
+		visited[name] = true
+		if fallback, ok := environments[name]; ok {
+			queue = append(queue, fallback.Fallback...)
+		}
+	}
+	return false
+}
+
+//获取已注册的环境,返回副本
+func GetEnvironment(name string) (*Environment, bool) {
+	environmentLock.RLock()
+	defer environmentLock.RUnlock()
+	env, ok := environments[name]
+	if !ok {
+		return nil, false
+	}
+	return env.copy(), true
+}
+
+func (env *Environment) copy() *Environment {
+	result := *env
+	result.Fallback = append([]string(nil), env.Fallback...)
+	return &result
+}
+
+//环境的完整回退链,按优先级从高到低,不包含自己
+func environmentFallback(name string) []string {
+	result := make([]string, 0)
+	visited := map[string]bool{name: true}
+	queue := []string{name}
+	for len(queue) > 0 {
+		env, ok := GetEnvironment(queue[0])
+		queue = queue[1:]
+		if !ok {
+			continue
+		}
+		for _, fallback := range env.Fallback {
+			if visited[fallback] {
+				continue
+			}
+			visited[fallback] = true
+			result = append(result, fallback)
+			queue = append(queue, fallback)
+		}
+	}
+	return result
+}
+
+//是否是某个环境
+func (config *config) IsEnv(name string) bool {
+	return config.Environment == name
+}
+
+//是否是生产或类生产环境
+func (config *config) IsProductionLike() bool {
+	env, ok := GetEnvironment(config.Environment)
+	if !ok {
+		return false
+	}
+	return env.Tier == EnvTierProduction
+}
@@ -0,0 +1,142 @@
+package frame
+
+import (
+	"bytes"
+	"errors"
+	"github.com/BurntSushi/toml"
+	"io/ioutil"
+	"os"
+)
+
+// 配置分层
+// 目录结构:
+//	<envPath>/base/redis/main.toml      公共配置
+//	<envPath>/develop/redis/main.yaml   环境配置
+// 每层的文件格式可以不同,读取时先读公共配置,再用环境配置深度合并,环境配置没有设置的字段保留公共配置的值
+// 环境注册了回退链的,回退环境的配置位于公共配置和环境配置之间
+// 数组(如 slaves)整体覆盖,不做合并
+// 每个字段来自哪一层可以通过 frame.App().EnvSource() 查看
+
+const ConfigLayerBase = "base"
+
+type configLayer struct {
+	name string //层名称,base或者环境名称
+	path string //配置文件路径(不包含扩展名)
+}
+
+//该层实际存在的配置文件,按注册的扩展名顺序查找
+func (layer *configLayer) file() (string, string, bool) {
+	for _, ext := range configExts() {
+		if fileExists(layer.path + "." + ext) {
+			return layer.path + "." + ext, ext, true
+		}
+	}
+	return "", "", false
+}
+
+func (layer *configLayer) exists() bool {
+	_, _, ok := layer.file()
+	return ok
+}
+
+//读取该层配置
+func (layer *configLayer) load() (map[string]interface{}, error) {
+	filePath, ext, ok := layer.file()
+	if !ok {
+		return nil, ConfigFileError
+	}
+	decoder, _ := getConfigDecoder(ext)
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	result, err := decoder(data)
+	if err != nil {
+		return nil, errors.New(filePath + ":" + err.Error())
+	}
+	return result, nil
+}
+
+//配置文件的所有层,优先级从低到高
+func (config *config) configLayers(configFile string) []*configLayer {
+	layers := make([]*configLayer, 0)
+	if config.BasePath != "" && config.BasePath != config.EnvPath {
+		layers = append(layers, &configLayer{name: ConfigLayerBase, path: config.BasePath + "/" + configFile})
+	}
+	//回退环境,优先级低的在前
+	if config.envRoot != "" {
+		fallback := environmentFallback(config.Environment)
+		for i := len(fallback) - 1; i >= 0; i-- {
+			layers = append(layers, &configLayer{name: fallback[i], path: config.envRoot + "/" + fallback[i] + "/" + configFile})
+		}
+	}
+	if config.EnvPath != "" {
+		layers = append(layers, &configLayer{name: config.Environment, path: config.EnvPath + "/" + configFile})
+	}
+	return layers
+}
+
+//读取所有层的配置并合并
+// sources 记录字段来源
+func loadConfLayers(layers []*configLayer, sources map[string]string) (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+	for _, layer := range layers {
+		data, err := layer.load()
+		if err != nil {
+			return nil, err
+		}
+		mergeConfMap(result, data, layer.name, "", sources)
+	}
+	return result, nil
+}
+
+//深度合并配置,src覆盖dst
+func mergeConfMap(dst map[string]interface{}, src map[string]interface{}, layer string, prefix string, sources map[string]string) {
+	for k, v := range src {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		srcMap, srcIsMap := v.(map[string]interface{})
+		dstMap, dstIsMap := dst[k].(map[string]interface{})
+		if srcIsMap {
+			if !dstIsMap {
+				dstMap = make(map[string]interface{})
+				dst[k] = dstMap
+				clearConfSource(sources, key)
+			}
+			mergeConfMap(dstMap, srcMap, layer, key, sources)
+			continue
+		}
+		if dstIsMap {
+			clearConfSource(sources, key)
+		}
+		dst[k] = v
+		sources[key] = layer
+	}
+}
+
+//字段被整体覆盖后删除下级字段的来源
+func clearConfSource(sources map[string]string, key string) {
+	for k := range sources {
+		if k == key || (len(k) > len(key) && k[:len(key)+1] == key+".") {
+			delete(sources, k)
+		}
+	}
+}
+
+//合并后的配置写入结构体
+func decodeConfMap(data map[string]interface{}, configStruct interface{}) error {
+	buffer := &bytes.Buffer{}
+	if err := toml.NewEncoder(buffer).Encode(data); err != nil {
+		return err
+	}
+	_, err := toml.Decode(buffer.String(), configStruct)
+	return err
+}
+
+//文件是否存在
+func fileExists(filePath string) bool {
+	info, err := os.Stat(filePath)
+	return err == nil && !info.IsDir()
+}
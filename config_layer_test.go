@@ -0,0 +1,95 @@
+package frame
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConfigLayerMerge(t *testing.T) {
+	testApp, clean := newTestApp(t, map[string]string{
+		"base/test/only.toml": `
+host = "base"
+servers = ["a:1", "b:2"]
+`,
+		"base/test/scalar.toml": `
+host = "base"
+port = 1
+`,
+		"develop/test/scalar.yaml": `
+port: 2
+`,
+		"base/test/table.toml": `
+[master]
+host = "base"
+port = 1
+`,
+		"develop/test/table.json": `{"master": {"port": 2}}`,
+		"base/test/slice.toml": `
+[[slaves]]
+host = "s0"
+[[slaves]]
+host = "s1"
+`,
+		"develop/test/slice.toml": `
+[[slaves]]
+host = "x"
+port = 3
+`,
+		"develop/test/env.toml": `
+host = "develop"
+`,
+	})
+	defer clean()
+
+	tests := []struct {
+		configFile string
+		value      *overrideTestConfig
+		source     map[string]string
+	}{
+		{
+			configFile: "test/only",
+			value:      &overrideTestConfig{Host: "base", Servers: []string{"a:1", "b:2"}},
+			source:     map[string]string{"host": ConfigLayerBase, "servers": ConfigLayerBase},
+		},
+		{
+			configFile: "test/scalar",
+			value:      &overrideTestConfig{Host: "base", Port: 2},
+			source:     map[string]string{"host": ConfigLayerBase, "port": "develop"},
+		},
+		{
+			configFile: "test/table",
+			value:      &overrideTestConfig{Master: &overrideTestHost{Host: "base", Port: 2}},
+			source:     map[string]string{"master.host": ConfigLayerBase, "master.port": "develop"},
+		},
+		{
+			configFile: "test/slice",
+			value:      &overrideTestConfig{Slaves: []*overrideTestHost{{Host: "x", Port: 3}}},
+			source:     map[string]string{"slaves": "develop"},
+		},
+		{
+			configFile: "test/env",
+			value:      &overrideTestConfig{Host: "develop"},
+			source:     map[string]string{"host": "develop"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.configFile, func(t *testing.T) {
+			config := &overrideTestConfig{}
+			sources, err := testApp.EnvSource(test.configFile, config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(config, test.value) {
+				t.Fatalf("配置 %+v,需要 %+v", config, test.value)
+			}
+			if !reflect.DeepEqual(sources, test.source) {
+				t.Fatalf("来源 %v,需要 %v", sources, test.source)
+			}
+			//不需要来源时结果相同
+			plain := &overrideTestConfig{}
+			if err := testApp.Env(test.configFile, plain); err != nil || !reflect.DeepEqual(plain, test.value) {
+				t.Fatalf("配置 %+v,需要 %+v,错误 %v", plain, test.value, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,279 @@
+package frame
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 配置覆盖
+// 配置文件读取之后,可以通过环境变量或者命令行参数覆盖其中任意字段,优先级 命令行参数 > 环境变量 > 配置文件
+// 字段路径由toml标签(没有标签使用字段名)组成,切片使用下标,如 redis/main 中的 master.host, slaves.0.host
+//  环境变量: FRAME_REDIS_MAIN_MASTER_HOST=127.0.0.1 (非字母数字的字符转为下划线,全部大写)
+//  命令行参数: -config.redis/main.master.host=127.0.0.1
+// 基础类型的切片使用逗号分隔,如 FRAME_MEMCACHE_MAIN_SERVERS=127.0.0.1:11211,127.0.0.1:11212
+// 表的切片可以通过下标追加元素,如配置文件只有一个从库时 -config.db/main.slaves.1.host=10.0.0.2 新增第二个从库,下标需要连续
+// 覆盖在读取配置文件之后进行,配置文件(或公共配置)必须存在,可以是空文件
+
+const ConfigEnvPrefix = "FRAME_"
+const ConfigFlagPrefix = "config."
+
+//配置覆盖来源
+const ConfigSourceEnv = "env"
+const ConfigSourceFlag = "flag"
+
+type configOverrider struct {
+	configFile string
+	envs       map[string]string //环境变量名 => 值
+	flags      map[string]string //命令行参数名 => 值
+	sources    map[string]string //被覆盖的字段路径 => 来源
+}
+
+//用环境变量和命令行参数覆盖配置
+// 返回被覆盖的字段路径和来源
+func configOverride(configFile string, configStruct interface{}) (map[string]string, error) {
+	overrider := newConfigOverrider(configFile)
+	if len(overrider.envs) == 0 && len(overrider.flags) == 0 {
+		return overrider.sources, nil
+	}
+	value := reflect.ValueOf(configStruct)
+	if value.Kind() != reflect.Ptr || value.IsNil() {
+		return overrider.sources, nil
+	}
+	_, err := overrider.walk(value, make([]string, 0))
+	return overrider.sources, err
+}
+
+func newConfigOverrider(configFile string) *configOverrider {
+	overrider := &configOverrider{
+		configFile: configFile,
+		envs:       make(map[string]string),
+		flags:      make(map[string]string),
+		sources:    make(map[string]string),
+	}
+	envPrefix := overrider.envKey(make([]string, 0))
+	for _, v := range os.Environ() {
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) == 2 && strings.HasPrefix(kv[0], envPrefix) {
+			overrider.envs[kv[0]] = kv[1]
+		}
+	}
+	flagPrefix := overrider.flagKey(make([]string, 0))
+	for k, v := range flagAll() {
+		if strings.HasPrefix(k, flagPrefix) {
+			overrider.flags[k] = v
+		}
+	}
+	return overrider
+}
+
+//环境变量名 FRAME_REDIS_MAIN_MASTER_HOST
+func (overrider *configOverrider) envKey(path []string) string {
+	key := overrider.configFile
+	if len(path) > 0 {
+		key += "_" + strings.Join(path, "_")
+	}
+	keyRune := []rune(strings.ToUpper(key))
+	for k, v := range keyRune {
+		if !(v >= 'A' && v <= 'Z') && !(v >= '0' && v <= '9') {
+			keyRune[k] = '_'
+		}
+	}
+	return ConfigEnvPrefix + string(keyRune)
+}
+
+//命令行参数名 config.redis/main.master.host
+func (overrider *configOverrider) flagKey(path []string) string {
+	key := ConfigFlagPrefix + overrider.configFile
+	if len(path) > 0 {
+		key += "." + strings.Join(path, ".")
+	}
+	return key
+}
+
+//查找字段对应的覆盖值
+func (overrider *configOverrider) lookup(path []string) (string, bool) {
+	flagKey := overrider.flagKey(path)
+	if v, ok := overrider.flags[flagKey]; ok {
+		overrider.sources[strings.Join(path, ".")] = ConfigSourceFlag + ":" + flagKey
+		return v, true
+	}
+	envKey := overrider.envKey(path)
+	if v, ok := overrider.envs[envKey]; ok {
+		overrider.sources[strings.Join(path, ".")] = ConfigSourceEnv + ":" + envKey
+		return v, true
+	}
+	return "", false
+}
+
+//字段下面是否有需要覆盖的值
+func (overrider *configOverrider) hasPrefix(path []string) bool {
+	flagKey := overrider.flagKey(path) + "."
+	for k := range overrider.flags {
+		if strings.HasPrefix(k, flagKey) {
+			return true
+		}
+	}
+	envKey := overrider.envKey(path) + "_"
+	for k := range overrider.envs {
+		if strings.HasPrefix(k, envKey) {
+			return true
+		}
+	}
+	return false
+}
+
+//遍历结构体,返回是否有字段被覆盖
+func (overrider *configOverrider) walk(value reflect.Value, path []string) (bool, error) {
+	switch value.Kind() {
+	case reflect.Ptr:
+		if value.IsNil() {
+			//未配置的表,有覆盖值时新建
+			if value.Type().Elem().Kind() != reflect.Struct || !overrider.hasPrefix(path) {
+				return false, nil
+			}
+			elem := reflect.New(value.Type().Elem())
+			set, err := overrider.walk(elem, path)
+			if set && value.CanSet() {
+				value.Set(elem)
+			}
+			return set, err
+		}
+		return overrider.walk(value.Elem(), path)
+	case reflect.Struct:
+		set := false
+		valueType := value.Type()
+		for i := 0; i < value.NumField(); i++ {
+			field := valueType.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			key := configFieldKey(field)
+			if key == "-" {
+				continue
+			}
+			fieldSet, err := overrider.walk(value.Field(i), append(path[:len(path):len(path)], key))
+			if err != nil {
+				return set, err
+			}
+			set = set || fieldSet
+		}
+		return set, nil
+	case reflect.Slice:
+		elemKind := value.Type().Elem().Kind()
+		if elemKind == reflect.Struct || elemKind == reflect.Ptr {
+			set := false
+			for i := 0; i < value.Len(); i++ {
+				elemSet, err := overrider.walk(value.Index(i), append(path[:len(path):len(path)], strconv.Itoa(i)))
+				if err != nil {
+					return set, err
+				}
+				set = set || elemSet
+			}
+			//下标超出配置文件的,有覆盖值时追加
+			for i := value.Len(); value.CanSet(); i++ {
+				elemPath := append(path[:len(path):len(path)], strconv.Itoa(i))
+				if !overrider.hasPrefix(elemPath) {
+					break
+				}
+				elem := reflect.New(value.Type().Elem()).Elem()
+				elemSet, err := overrider.walk(elem.Addr(), elemPath)
+				if err != nil {
+					return set, err
+				}
+				if !elemSet {
+					break
+				}
+				value.Set(reflect.Append(value, elem))
+				set = true
+			}
+			return set, nil
+		}
+		return overrider.setValue(value, path)
+	case reflect.Map, reflect.Interface, reflect.Chan, reflect.Func:
+		return false, nil
+	default:
+		return overrider.setValue(value, path)
+	}
+}
+
+func (overrider *configOverrider) setValue(value reflect.Value, path []string) (bool, error) {
+	str, ok := overrider.lookup(path)
+	if !ok || !value.CanSet() {
+		return false, nil
+	}
+	if err := setConfigValue(value, str); err != nil {
+		return false, errors.New(overrider.configFile + ":" + strings.Join(path, ".") + ":" + err.Error())
+	}
+	return true, nil
+}
+
+//字段在配置文件中的名称
+func configFieldKey(field reflect.StructField) string {
+	tag := field.Tag.Get("toml")
+	if tag != "" {
+		name := strings.Split(tag, ",")[0]
+		if name != "" {
+			return name
+		}
+	}
+	return field.Name
+}
+
+//字符串转换成字段类型并赋值
+func setConfigValue(value reflect.Value, str string) error {
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(str)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(str)
+		if err != nil {
+			return err
+		}
+		value.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if value.Type() == reflect.TypeOf(time.Duration(0)) {
+			d, err := time.ParseDuration(str)
+			if err != nil {
+				return err
+			}
+			value.SetInt(int64(d))
+			return nil
+		}
+		i, err := strconv.ParseInt(str, 10, value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		value.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		i, err := strconv.ParseUint(str, 10, value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		value.SetUint(i)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(str, value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		value.SetFloat(f)
+	case reflect.Slice:
+		items := make([]string, 0)
+		if str != "" {
+			items = strings.Split(str, ",")
+		}
+		slice := reflect.MakeSlice(value.Type(), len(items), len(items))
+		for k, v := range items {
+			if err := setConfigValue(slice.Index(k), strings.TrimSpace(v)); err != nil {
+				return err
+			}
+		}
+		value.Set(slice)
+	default:
+		return errors.New("不支持的配置类型 " + value.Type().String())
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package frame
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+//临时目录中的应用,files 为 配置文件相对路径 => 内容,内容中的 {dir} 替换为临时目录,默认日志写入临时目录
+func newTestApp(t *testing.T, files map[string]string) (*app, func()) {
+	dir, err := ioutil.TempDir("", "frame_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := files["develop/app.toml"]; !ok {
+		files["develop/app.toml"] = "[log]\npath = \"" + filepath.Join(dir, "logs") + "\"\n"
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		content = strings.Replace(content, "{dir}", dir, -1)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	testApp := newApp().Init("develop", "frame_test", dir)
+	return testApp, func() {
+		_ = testApp.Shutdown()
+		_ = os.RemoveAll(dir)
+	}
+}
+
+//设置环境变量和命令行参数,返回恢复的方法
+func setTestArgs(envs map[string]string, args []string) func() {
+	osArgs := os.Args
+	os.Args = append([]string{osArgs[0]}, args...)
+	for k, v := range envs {
+		_ = os.Setenv(k, v)
+	}
+	flagLock.Lock()
+	flagParams = nil
+	flagLock.Unlock()
+	return func() {
+		os.Args = osArgs
+		for k := range envs {
+			_ = os.Unsetenv(k)
+		}
+		flagLock.Lock()
+		flagParams = nil
+		flagLock.Unlock()
+	}
+}
+
+type overrideTestHost struct {
+	Host string `toml:"host"`
+	Port int    `toml:"port"`
+}
+
+type overrideTestConfig struct {
+	Host    string              `toml:"host"`
+	Port    int                 `toml:"port"`
+	Timeout time.Duration       `toml:"timeout"`
+	Servers []string            `toml:"servers"`
+	Master  *overrideTestHost   `toml:"master"`
+	Slaves  []*overrideTestHost `toml:"slaves"`
+}
+
+func TestConfigOverride(t *testing.T) {
+	testApp, clean := newTestApp(t, map[string]string{
+		"develop/test/main.toml": `
+host = "127.0.0.1"
+port = 3306
+servers = ["a:1"]
+[[slaves]]
+host = "s0"
+port = 1
+`,
+	})
+	defer clean()
+
+	tests := []struct {
+		name    string
+		envs    map[string]string
+		args    []string
+		want    func(config *overrideTestConfig) interface{}
+		value   interface{}
+		source  map[string]string
+		wantErr bool
+	}{
+		{
+			name:   "env",
+			envs:   map[string]string{"FRAME_TEST_MAIN_HOST": "10.0.0.1"},
+			want:   func(config *overrideTestConfig) interface{} { return config.Host },
+			value:  "10.0.0.1",
+			source: map[string]string{"host": "env:FRAME_TEST_MAIN_HOST"},
+		},
+		{
+			name:   "flag before env",
+			envs:   map[string]string{"FRAME_TEST_MAIN_PORT": "1"},
+			args:   []string{"-config.test/main.port=2"},
+			want:   func(config *overrideTestConfig) interface{} { return config.Port },
+			value:  2,
+			source: map[string]string{"port": "flag:config.test/main.port"},
+		},
+		{
+			name:  "duration",
+			args:  []string{"-config.test/main.timeout=1500ms"},
+			want:  func(config *overrideTestConfig) interface{} { return config.Timeout },
+			value: 1500 * time.Millisecond,
+		},
+		{
+			name:  "basic slice",
+			envs:  map[string]string{"FRAME_TEST_MAIN_SERVERS": "a:1, b:2"},
+			want:  func(config *overrideTestConfig) interface{} { return config.Servers },
+			value: []string{"a:1", "b:2"},
+		},
+		{
+			name:  "new table",
+			envs:  map[string]string{"FRAME_TEST_MAIN_MASTER_HOST": "m"},
+			want:  func(config *overrideTestConfig) interface{} { return *config.Master },
+			value: overrideTestHost{Host: "m"},
+		},
+		{
+			name:  "existing element",
+			args:  []string{"-config.test/main.slaves.0.host=s0x"},
+			want:  func(config *overrideTestConfig) interface{} { return *config.Slaves[0] },
+			value: overrideTestHost{Host: "s0x", Port: 1},
+		},
+		{
+			name: "append element",
+			envs: map[string]string{"FRAME_TEST_MAIN_SLAVES_1_PORT": "2"},
+			args: []string{"-config.test/main.slaves.1.host=s1"},
+			want: func(config *overrideTestConfig) interface{} {
+				return []overrideTestHost{*config.Slaves[0], *config.Slaves[1]}
+			},
+			value: []overrideTestHost{{Host: "s0", Port: 1}, {Host: "s1", Port: 2}},
+			source: map[string]string{
+				"slaves.1.host": "flag:config.test/main.slaves.1.host",
+				"slaves.1.port": "env:FRAME_TEST_MAIN_SLAVES_1_PORT",
+			},
+		},
+		{
+			name:  "index gap",
+			args:  []string{"-config.test/main.slaves.2.host=s2"},
+			want:  func(config *overrideTestConfig) interface{} { return len(config.Slaves) },
+			value: 1,
+		},
+		{
+			name:    "invalid value",
+			envs:    map[string]string{"FRAME_TEST_MAIN_PORT": "abc"},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			restore := setTestArgs(test.envs, test.args)
+			defer restore()
+			config := &overrideTestConfig{}
+			sources, err := testApp.EnvSource("test/main", config)
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("需要返回错误")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := test.want(config); !reflect.DeepEqual(got, test.value) {
+				t.Fatalf("值 %v,需要 %v", got, test.value)
+			}
+			for k, v := range test.source {
+				if sources[k] != v {
+					t.Fatalf("%s 来源 %s,需要 %s", k, sources[k], v)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,259 @@
+package frame
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"golang.org/x/crypto/pbkdf2"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// 配置中的密钥引用
+// 读取配置时解析以下格式的字符串:
+//	${env:DB_PASS}              读取环境变量
+//	${file:/run/secrets/db}     读取文件内容(去掉末尾换行)
+//	enc:AES256GCM:xxxx          解密,整个值必须是加密串
+// ${...} 可以出现在字符串中间,如 "user:${env:DB_PASS}"
+// 解密密钥通过命令行参数 -config-key 或者环境变量 FRAME_CONFIG_KEY 传入
+// 加密串为 base64(盐 + nonce + 密文),AES密钥由传入的密钥和盐通过 PBKDF2-SHA256 生成
+// 加密可以使用 go run cmd/encrypt/main.go -config-key=xxx -value=明文
+
+const ConfigKeyFlag = "config-key"
+const ConfigKeyEnv = "FRAME_CONFIG_KEY"
+const ConfigEncryptPrefix = "enc:AES256GCM:"
+
+//解析后的密钥打码显示
+const secretMask = "******"
+
+//PBKDF2 的盐长度和迭代次数
+const configSaltSize = 16
+const configKdfIterations = 100000
+
+var secretReference = regexp.MustCompile(`\$\{(env|file):([^}]+)\}`)
+
+//已解析的密钥,日志中需要打码
+var secretValues = make(map[string]bool)
+var secretLock sync.RWMutex
+
+//sha256(密钥)+盐 => AES密钥,进程内缓存,每次读取配置不需要重新计算
+var configDerivedKeys = make(map[string][]byte)
+var configDerivedLock sync.RWMutex
+
+//解析结构体中所有字符串的密钥引用
+func resolveConfigSecret(configFile string, configStruct interface{}) error {
+	key := ""
+	return walkConfigString(reflect.ValueOf(configStruct), func(str string) (string, error) {
+		if strings.HasPrefix(str, ConfigEncryptPrefix) {
+			if key == "" {
+				key = configSecretKey()
+				if key == "" {
+					return "", errors.New(configFile + ":" + ConfigSecretError.Error() + ":没有设置解密密钥")
+				}
+			}
+			plain, err := decryptConfigValue(str, key)
+			if err != nil {
+				return "", errors.New(configFile + ":" + ConfigSecretError.Error() + ":" + err.Error())
+			}
+			addSecretValue(plain)
+			return plain, nil
+		}
+		if !strings.Contains(str, "${") {
+			return str, nil
+		}
+		var resolveErr error
+		result := secretReference.ReplaceAllStringFunc(str, func(ref string) string {
+			match := secretReference.FindStringSubmatch(ref)
+			value := ""
+			switch match[1] {
+			case "env":
+				v, ok := os.LookupEnv(match[2])
+				if !ok {
+					resolveErr = errors.New(configFile + ":" + ConfigSecretError.Error() + ":环境变量不存在 " + match[2])
+				}
+				value = v
+			case "file":
+				content, err := ioutil.ReadFile(match[2])
+				if err != nil {
+					resolveErr = errors.New(configFile + ":" + ConfigSecretError.Error() + ":" + err.Error())
+				}
+				value = strings.TrimRight(string(content), "\r\n")
+			}
+			addSecretValue(value)
+			return value
+		})
+		return result, resolveErr
+	})
+}
+
+//遍历结构体中的字符串和字符串切片
+func walkConfigString(value reflect.Value, f func(str string) (string, error)) error {
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !value.IsNil() {
+			return walkConfigString(value.Elem(), f)
+		}
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			if value.Type().Field(i).PkgPath != "" {
+				continue
+			}
+			if err := walkConfigString(value.Field(i), f); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			if err := walkConfigString(value.Index(i), f); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if value.Type().Elem().Kind() != reflect.String {
+			return nil
+		}
+		for _, k := range value.MapKeys() {
+			str, err := f(value.MapIndex(k).String())
+			if err != nil {
+				return err
+			}
+			value.SetMapIndex(k, reflect.ValueOf(str).Convert(value.Type().Elem()))
+		}
+	case reflect.String:
+		str, err := f(value.String())
+		if err != nil {
+			return err
+		}
+		if value.CanSet() {
+			value.SetString(str)
+		}
+	}
+	return nil
+}
+
+func addSecretValue(str string) {
+	if str == "" {
+		return
+	}
+	secretLock.Lock()
+	defer secretLock.Unlock()
+	secretValues[str] = true
+}
+
+//是否是配置中解析出来的密钥
+func isSecretValue(str string) bool {
+	secretLock.RLock()
+	defer secretLock.RUnlock()
+	return secretValues[str]
+}
+
+//密钥打码,用于写日志的配置和报错信息,包含密钥的值也整体打码
+func maskSecret(str string) string {
+	if isSecretValue(str) {
+		return secretMask
+	}
+	secretLock.RLock()
+	defer secretLock.RUnlock()
+	for secret := range secretValues {
+		if strings.Contains(str, secret) {
+			return secretMask
+		}
+	}
+	return str
+}
+
+//解密密钥,没有设置返回空
+func configSecretKey() string {
+	key := convertToString(GetFlag(ConfigKeyFlag, ""))
+	if key == "" {
+		key = os.Getenv(ConfigKeyEnv)
+	}
+	return key
+}
+
+//由密钥和盐生成AES-256的密钥
+func deriveConfigKey(key string, salt []byte) []byte {
+	return pbkdf2.Key([]byte(key), salt, configKdfIterations, 32, sha256.New)
+}
+
+//解密时使用,同一个密钥和盐只计算一次
+// 连接池等每次请求都会读取配置,不缓存的话每次都要做一次PBKDF2
+func cachedConfigKey(key string, salt []byte) []byte {
+	keyHash := sha256.Sum256([]byte(key))
+	cacheKey := string(keyHash[:]) + string(salt)
+	configDerivedLock.RLock()
+	aesKey, ok := configDerivedKeys[cacheKey]
+	configDerivedLock.RUnlock()
+	if ok {
+		return aesKey
+	}
+	aesKey = deriveConfigKey(key, salt)
+	configDerivedLock.Lock()
+	configDerivedKeys[cacheKey] = aesKey
+	configDerivedLock.Unlock()
+	return aesKey
+}
+
+//加密配置值,返回 enc:AES256GCM: 开头的字符串,可以直接写入配置文件
+// value 明文
+// key 密钥,读取配置时通过 -config-key 或者 FRAME_CONFIG_KEY 传入同样的值
+func EncryptConfigValue(value string, key string) (string, error) {
+	if key == "" {
+		return "", ConfigSecretError
+	}
+	salt := make([]byte, configSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return "", err
+	}
+	gcm, err := newConfigGcm(deriveConfigKey(key, salt))
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	cipherText := gcm.Seal(append(salt, nonce...), nonce, []byte(value), nil)
+	return ConfigEncryptPrefix + base64.StdEncoding.EncodeToString(cipherText), nil
+}
+
+//解密 enc:AES256GCM: 开头的配置值
+func decryptConfigValue(value string, key string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, ConfigEncryptPrefix))
+	if err != nil {
+		return "", err
+	}
+	if len(data) < configSaltSize {
+		return "", errors.New("密文长度错误")
+	}
+	aesKey := cachedConfigKey(key, data[:configSaltSize])
+	data = data[configSaltSize:]
+	gcm, err := newConfigGcm(aesKey)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", errors.New("密文长度错误")
+	}
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+func newConfigGcm(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
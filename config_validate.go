@@ -0,0 +1,309 @@
+package frame
+
+import (
+	"io/ioutil"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 配置校验
+// App().Env 读取配置之后根据结构体的validate标签进行校验,所有不合法的字段汇总成一个错误返回
+// 支持的规则,多个规则用逗号分隔:
+//	required     必填,字符串/切片不能为空,数字不能为0,指针不能为nil
+//	min=1        数字的最小值,字符串/切片的最小长度
+//	max=65535    数字的最大值,字符串/切片的最大长度
+//	oneof=a b    只能是其中之一,多个值用空格分隔
+//	hostport     host:port格式,字符串切片校验每一个元素
+// 如:
+//	Port int `toml:"port" validate:"required,min=1,max=65535"`
+
+//单个字段的校验错误
+type ConfigFieldError struct {
+	File    string //配置文件,如 db/main
+	Key     string //字段路径,如 master.host
+	Rule    string //不满足的规则
+	Message string
+}
+
+func (fieldError *ConfigFieldError) Error() string {
+	if fieldError.Key == "" {
+		return fieldError.File + ": " + fieldError.Message
+	}
+	return fieldError.File + ": " + fieldError.Key + ": " + fieldError.Message
+}
+
+//汇总的校验错误
+type ConfigValidateError struct {
+	Errors []*ConfigFieldError
+}
+
+func (validateError *ConfigValidateError) Error() string {
+	messages := make([]string, 0)
+	for _, v := range validateError.Errors {
+		messages = append(messages, v.Error())
+	}
+	return ConfigValidError.Error() + ":\n" + strings.Join(messages, "\n")
+}
+
+//校验配置,没有错误返回nil
+func validateConfig(configFile string, configStruct interface{}) error {
+	validator := &configValidator{configFile: configFile, errors: make([]*ConfigFieldError, 0)}
+	validator.walk(reflect.ValueOf(configStruct), make([]string, 0))
+	if len(validator.errors) == 0 {
+		return nil
+	}
+	return &ConfigValidateError{Errors: validator.errors}
+}
+
+type configValidator struct {
+	configFile string
+	errors     []*ConfigFieldError
+}
+
+func (validator *configValidator) addError(path []string, rule string, message string) {
+	validator.errors = append(validator.errors, &ConfigFieldError{
+		File:    validator.configFile,
+		Key:     strings.Join(path, "."),
+		Rule:    rule,
+		Message: message,
+	})
+}
+
+func (validator *configValidator) walk(value reflect.Value, path []string) {
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !value.IsNil() {
+			validator.walk(value.Elem(), path)
+		}
+	case reflect.Struct:
+		valueType := value.Type()
+		for i := 0; i < value.NumField(); i++ {
+			field := valueType.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			key := configFieldKey(field)
+			if key == "-" {
+				continue
+			}
+			fieldPath := append(path[:len(path):len(path)], key)
+			if tag := field.Tag.Get("validate"); tag != "" {
+				validator.check(value.Field(i), fieldPath, tag)
+			}
+			validator.walk(value.Field(i), fieldPath)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			validator.walk(value.Index(i), append(path[:len(path):len(path)], strconv.Itoa(i)))
+		}
+	}
+}
+
+func (validator *configValidator) check(value reflect.Value, path []string, tag string) {
+	for _, rule := range strings.Split(tag, ",") {
+		rule = strings.TrimSpace(rule)
+		name, param := rule, ""
+		if pos := strings.Index(rule, "="); pos > 0 {
+			name, param = rule[:pos], rule[pos+1:]
+		}
+		switch name {
+		case "required":
+			if isEmptyConfigValue(value) {
+				validator.addError(path, rule, "必填")
+				//必填的为空时其他规则不再校验
+				return
+			}
+		case "min", "max":
+			limit, err := strconv.ParseFloat(param, 64)
+			if err != nil {
+				validator.addError(path, rule, "规则错误")
+				continue
+			}
+			size, ok := configValueSize(value)
+			if !ok {
+				continue
+			}
+			if name == "min" && size < limit {
+				validator.addError(path, rule, "不能小于"+param)
+			}
+			if name == "max" && size > limit {
+				validator.addError(path, rule, "不能大于"+param)
+			}
+		case "oneof":
+			if isEmptyConfigValue(value) {
+				continue
+			}
+			str := configValueString(value)
+			if !inStringSlice(str, strings.Fields(param)) {
+				validator.addError(path, rule, "只能是"+strings.Join(strings.Fields(param), "|")+",当前值:"+maskSecret(str))
+			}
+		case "hostport":
+			for _, str := range configValueStrings(value) {
+				if !isHostPort(str) {
+					validator.addError(path, rule, "不是host:port格式:"+maskSecret(str))
+				}
+			}
+		default:
+			validator.addError(path, rule, "不支持的校验规则")
+		}
+	}
+}
+
+func isEmptyConfigValue(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return value.IsNil()
+	case reflect.Slice, reflect.Map, reflect.String, reflect.Array:
+		return value.Len() == 0
+	case reflect.Bool:
+		return !value.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return value.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return value.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return value.Float() == 0
+	}
+	return false
+}
+
+//数字返回值,字符串/切片返回长度
+func configValueSize(value reflect.Value) (float64, bool) {
+	switch value.Kind() {
+	case reflect.Slice, reflect.Map, reflect.String, reflect.Array:
+		return float64(value.Len()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), true
+	}
+	return 0, false
+}
+
+func configValueString(value reflect.Value) string {
+	switch value.Kind() {
+	case reflect.String:
+		return value.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(value.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(value.Uint(), 10)
+	}
+	return ""
+}
+
+func configValueStrings(value reflect.Value) []string {
+	if value.Kind() == reflect.String {
+		return []string{value.String()}
+	}
+	result := make([]string, 0)
+	if value.Kind() == reflect.Slice && value.Type().Elem().Kind() == reflect.String {
+		for i := 0; i < value.Len(); i++ {
+			result = append(result, value.Index(i).String())
+		}
+	}
+	return result
+}
+
+func isHostPort(str string) bool {
+	host, port, err := net.SplitHostPort(str)
+	if err != nil || host == "" {
+		return false
+	}
+	portNum, err := strconv.Atoi(port)
+	return err == nil && portNum > 0 && portNum <= 65535
+}
+
+/**
+启动前检查
+*/
+
+//需要检查的资源目录 => 对应的配置结构体
+var preflightResources = map[string]func() interface{}{
+	"db":       func() interface{} { return &dbHost{} },
+	"redis":    func() interface{} { return &redisHost{} },
+	"memcache": func() interface{} { return &memCacheConfig{} },
+	"curl":     func() interface{} { return &curlClientConfig{} },
+}
+var preflightLock sync.RWMutex
+
+//注册启动前需要检查的配置目录
+// dir 配置目录,如 redis
+// newStruct 返回该目录下配置文件对应的结构体
+func RegisterPreflight(dir string, newStruct func() interface{}) {
+	preflightLock.Lock()
+	defer preflightLock.Unlock()
+	preflightResources[dir] = newStruct
+}
+
+//启动前检查所有资源配置,所有错误汇总返回
+// dirs 需要检查的目录,不传检查所有注册的目录
+func (app *app) Preflight(dirs ...string) error {
+	preflightLock.RLock()
+	resources := make(map[string]func() interface{})
+	for dir, newStruct := range preflightResources {
+		if len(dirs) == 0 || inStringSlice(dir, dirs) {
+			resources[dir] = newStruct
+		}
+	}
+	preflightLock.RUnlock()
+
+	errs := make([]*ConfigFieldError, 0)
+	dirNames := make([]string, 0)
+	for dir := range resources {
+		dirNames = append(dirNames, dir)
+	}
+	sort.Strings(dirNames)
+	for _, dir := range dirNames {
+		for _, configFile := range app.listConfigFiles(dir) {
+			err := app.Env(configFile, resources[dir]())
+			if err == nil {
+				continue
+			}
+			if validateError, ok := err.(*ConfigValidateError); ok {
+				errs = append(errs, validateError.Errors...)
+			} else {
+				errs = append(errs, &ConfigFieldError{File: configFile, Message: err.Error()})
+			}
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return &ConfigValidateError{Errors: errs}
+}
+
+//目录下所有层的配置文件,如 redis/main
+func (config *config) listConfigFiles(dir string) []string {
+	names := make(map[string]bool)
+	exts := configExts()
+	for _, layer := range config.configLayers(dir) {
+		files, err := ioutil.ReadDir(layer.path)
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			if file.IsDir() {
+				continue
+			}
+			for _, ext := range exts {
+				if strings.HasSuffix(file.Name(), "."+ext) {
+					names[dir+"/"+strings.TrimSuffix(file.Name(), "."+ext)] = true
+					break
+				}
+			}
+		}
+	}
+	result := make([]string, 0)
+	for name := range names {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,159 @@
+package frame
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// 配置文件热加载
+// 用法:
+//	frame.App().WatchEnv("redis/main", func(configFile string) {
+//		//重新读取配置
+//	})
+//	frame.App().StartConfigWatch(5)
+// 开启后定时检查已注册配置文件的修改时间,发生变化后依次调用注册的回调
+// db/redis/memcached 连接池在首次建立时会自动注册,配置变化后重建连接池
+
+type configWatcher struct {
+	lock     sync.Mutex
+	items    map[string]*configWatchItem
+	stopChan chan bool
+}
+
+type configWatchItem struct {
+	fileStat map[string]string //文件路径 => 修改时间+大小,文件不存在为空
+	handles  []func(configFile string)
+}
+
+func newConfigWatcher() *configWatcher {
+	return &configWatcher{items: make(map[string]*configWatchItem)}
+}
+
+//默认检查间隔(秒)
+const configWatchInterval = 5
+
+//注册配置文件变化后的回调
+// configFile 配置文件路径，如redis/main
+func (app *app) WatchEnv(configFile string, f func(configFile string)) {
+	app.watchEnv(configFile, app.configFileStat(configFile), f)
+}
+
+//fileStat 为读取配置之前的文件状态,读取配置期间文件被修改时下次检查会触发回调
+func (app *app) watchEnv(configFile string, fileStat map[string]string, f func(configFile string)) {
+	app.watcher.lock.Lock()
+	defer app.watcher.lock.Unlock()
+	item, ok := app.watcher.items[configFile]
+	if !ok {
+		item = &configWatchItem{
+			fileStat: fileStat,
+			handles:  make([]func(configFile string), 0),
+		}
+		app.watcher.items[configFile] = item
+	}
+	item.handles = append(item.handles, f)
+}
+
+//开启配置文件监听
+// interval 检查间隔(秒),默认5秒
+func (app *app) StartConfigWatch(interval ...int) {
+	seconds := configWatchInterval
+	if len(interval) > 0 && interval[0] > 0 {
+		seconds = interval[0]
+	}
+	app.watcher.lock.Lock()
+	defer app.watcher.lock.Unlock()
+	if app.watcher.stopChan != nil {
+		return
+	}
+	stopChan := make(chan bool)
+	app.watcher.stopChan = stopChan
+	go func() {
+		ticker := time.NewTicker(time.Duration(seconds) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				app.ReloadEnv()
+			case <-stopChan:
+				return
+			}
+		}
+	}()
+}
+
+//关闭配置文件监听
+func (app *app) StopConfigWatch() {
+	app.watcher.lock.Lock()
+	defer app.watcher.lock.Unlock()
+	if app.watcher.stopChan != nil {
+		close(app.watcher.stopChan)
+		app.watcher.stopChan = nil
+	}
+}
+
+//检查配置文件是否变化,变化的调用注册的回调
+// configFiles 需要检查的配置文件,不传检查所有已注册的
+func (app *app) ReloadEnv(configFiles ...string) {
+	changed := make(map[string][]func(configFile string))
+	app.watcher.lock.Lock()
+	for configFile, item := range app.watcher.items {
+		if len(configFiles) > 0 && !inStringSlice(configFile, configFiles) {
+			continue
+		}
+		fileStat := app.configFileStat(configFile)
+		if sameFileStat(item.fileStat, fileStat) {
+			continue
+		}
+		item.fileStat = fileStat
+		handles := make([]func(configFile string), len(item.handles))
+		copy(handles, item.handles)
+		changed[configFile] = handles
+	}
+	app.watcher.lock.Unlock()
+	for configFile, handles := range changed {
+		for _, f := range handles {
+			app.runWatchHandle(configFile, f)
+		}
+	}
+}
+
+//配置文件对应的所有文件状态
+func (config *config) configFileStat(configFile string) map[string]string {
+	result := make(map[string]string)
+	for _, filePath := range config.configFilePaths(configFile) {
+		info, err := os.Stat(filePath)
+		if err != nil {
+			result[filePath] = ""
+			continue
+		}
+		result[filePath] = info.ModTime().String() + "|" + convertToString(info.Size())
+	}
+	return result
+}
+
+func sameFileStat(a map[string]string, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
+
+//单个回调报错不影响其他回调
+func (app *app) runWatchHandle(configFile string, f func(configFile string)) {
+	defer func() {
+		if err := recover(); err != nil {
+			msg := map[string]interface{}{
+				"config": configFile,
+				"error":  err,
+			}
+			app.Log.errorStack(msg, LogConfigError)
+		}
+	}()
+	f(configFile)
+}
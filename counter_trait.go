@@ -1,6 +1,6 @@
 package frame
 
-import "runtime/debug"
+import "context"
 
 const CounterTypeMc = "mc"
 const CounterTypeRedis = "redis"
@@ -25,6 +25,15 @@ type CounterTrait struct {
 	Group     string
 	Ttl       int
 	PreFixKey string
+	App       *app //所属应用,为空使用默认应用
+	ctx       context.Context
+}
+
+//设置上下文,报错日志带上上下文中的字段
+func (counterTrait *CounterTrait) WithContext(ctx context.Context) *CounterTrait {
+	counterTrait.ctx = ctx
+	counterTrait.counter = nil
+	return counterTrait
 }
 
 func (counterTrait *CounterTrait) GetCounter() Counter {
@@ -34,17 +43,20 @@ func (counterTrait *CounterTrait) GetCounter() Counter {
 	if counterTrait.Type == CounterTypeMc {
 		counterTrait.counter = &mcCounter{
 			GroupName: counterTrait.Group,
+			App:       counterTrait.App,
+			ctx:       counterTrait.ctx,
 		}
 	} else if counterTrait.Type == CounterTypeRedis {
 		counterTrait.counter = &redisCounter{
 			GroupName: counterTrait.Group,
+			App:       counterTrait.App,
+			ctx:       counterTrait.ctx,
 		}
 	} else {
 		msg := map[string]interface{}{
 			"error": CounterError,
-			"stack": string(debug.Stack()),
 		}
-		App().Log.Error(msg, LogCounterError)
+		contextLog(counterTrait.App, counterTrait.ctx).errorStack(msg, LogCounterError)
 		panic(CounterError)
 	}
 	return counterTrait.counter
@@ -1,5 +1,7 @@
 package frame
 
+import "context"
+
 // 数据库接口
 type Db interface {
 	Select(field ...interface{}) Db
@@ -10,14 +12,18 @@ type Db interface {
 	Insert(table string, info map[string]interface{}) Db
 	InsertBatch(table string, data []map[string]interface{}, onceMaxCounts ...int) Db
 	Update(table string, info map[string]interface{}) Db
+	UpdateBatch(table string, data []map[string]interface{}, key string, onceMaxCounts ...int) Db
 	Replace(table string, info map[string]interface{}) Db
 	ReplaceBatch(table string, data []map[string]interface{}, onceMaxCounts ...int) Db
+	Upsert(table string, info map[string]interface{}, updateColumns []interface{}) Db
+	UpsertBatch(table string, data []map[string]interface{}, updateColumns []interface{}, onceMaxCounts ...int) Db
 	Delete(table string) Db
 	Sql(preSql string, params ...interface{}) Db
 	From(table string, alias ...string) Db
 	Distinct() Db
 	Ignore() Db
 	ForceMaster() Db
+	WithContext(ctx context.Context) Db
 	Where(field string, value interface{}, ops ...string) Db
 	MultiWhere(conditions map[string]interface{}) Db
 	OrWhere(field string, value interface{}, ops ...string) Db
@@ -3,16 +3,20 @@ package frame
 import (
 	"database/sql"
 	_ "github.com/go-sql-driver/mysql"
-	"runtime/debug"
 	"strconv"
 	"sync"
 	"time"
 )
 
 type dbGroup struct {
-	Master *sql.DB
-	Slaves []*sql.DB
-	Config *dbConfig
+	Master       *sql.DB
+	Slaves       []*sql.DB
+	Config       *dbConfig
+	QueryTimeout time.Duration //单条SQL的默认超时时间,0不限制
+	transLock    sync.Mutex
+	trans        int  //进行中的事务数
+	retired      bool //已经被新的连接池替换,事务都结束后关闭
+	closed       bool
 }
 type dbConfig struct {
 	Host   string
@@ -21,118 +25,194 @@ type dbConfig struct {
 }
 
 type dbHost struct {
-	Type   string          `toml:"type"`
-	Master *dbHostConfig   `toml:"master"`
-	Slaves []*dbHostConfig `toml:"slaves"`
+	Type         string          `toml:"type" validate:"required,oneof=mysql"`
+	QueryTimeout int             `toml:"query_timeout" validate:"min=0"` //单条SQL的默认超时时间(毫秒),0不限制
+	Master       *dbHostConfig   `toml:"master" validate:"required"`
+	Slaves       []*dbHostConfig `toml:"slaves"` //为空时读也使用主库
 }
 type dbHostConfig struct {
-	Host            string `toml:"host"`
-	Port            int    `toml:"port"`
-	Username        string `toml:"username"`
+	Host            string `toml:"host" validate:"required"`
+	Port            int    `toml:"port" validate:"required,min=1,max=65535"`
+	Username        string `toml:"username" validate:"required"`
 	Password        string `toml:"password"`
-	DbName          string `toml:"dbname"`
+	DbName          string `toml:"dbname" validate:"required"`
 	Charset         string `toml:"charset"`
-	MaxOpenConn     int    `toml:"max_open_conns"`
-	MaxIdleConn     int    `toml:"max_idle_conns"`
-	ConnMaxLifeTime int    `toml:"conns_max_lifetime"`
+	MaxOpenConn     int    `toml:"max_open_conns" validate:"min=0"`
+	MaxIdleConn     int    `toml:"max_idle_conns" validate:"min=0"`
+	ConnMaxLifeTime int    `toml:"conns_max_lifetime" validate:"min=0"`
 }
 
-var dbGroupCache map[string]*dbGroup
-var dbGroupCacheOnce sync.Once
-var dbLock sync.Mutex
-
 //获取数据库连接池
-func openDB(dbGroups string) *dbGroup {
-	if dbGroupCache == nil {
-		dbGroupCacheOnce.Do(func() {
-			dbGroupCache = make(map[string]*dbGroup)
-		})
-	}
-	cache, ok := dbGroupCache[dbGroups]
+func (app *app) openDB(dbGroups string) *dbGroup {
+	res := app.resource
+	res.dbLock.RLock()
+	cache, ok := res.dbGroupCache[dbGroups]
+	res.dbLock.RUnlock()
 	if ok {
 		return cache
 	}
-	dbLock.Lock()
-	defer dbLock.Unlock()
-	dbHostConfig := &dbHost{}
-	err := App().Env(dbGroups, dbHostConfig)
+	res.dbLock.Lock()
+	defer res.dbLock.Unlock()
+	if cache, ok := res.dbGroupCache[dbGroups]; ok {
+		return cache
+	}
+	//先记录文件状态再读取配置,读取期间的修改不会被漏掉
+	fileStat := app.configFileStat(dbGroups)
+	group, err := app.newDbGroup(dbGroups)
 	if err != nil {
 		panic(DbError.Error() + ":" + err.Error())
 	}
-	dbType := dbHostConfig.Type
-	masterConfig := dbHostConfig.Master
-	dbDriver := masterConfig.Username + ":" +
-		masterConfig.Password + "@tcp(" +
-		masterConfig.Host + ":" +
-		strconv.Itoa(masterConfig.Port) + ")/" +
-		masterConfig.DbName + "?charset=" +
-		masterConfig.Charset
-	master, err := sql.Open(dbType, dbDriver)
+	res.dbGroupCache[dbGroups] = group
+	//配置文件变化后重建连接池
+	if !res.dbWatched[dbGroups] {
+		res.dbWatched[dbGroups] = true
+		app.watchEnv(dbGroups, fileStat, func(configFile string) {
+			_ = app.ReloadDB(configFile)
+		})
+	}
+	return group
+}
+
+// 重新建立默认应用的数据库连接池
+// 新的查询使用新的连接池,旧连接池延迟关闭,事务中的查询继续使用旧连接池直到结束
+// 延迟时间到了还有事务没有结束时,等最后一个事务提交或者回滚后关闭
+func ReloadDB(dbGroups string) error {
+	return App().ReloadDB(dbGroups)
+}
+
+//重新建立数据库连接池
+func (app *app) ReloadDB(dbGroups string) error {
+	group, err := app.newDbGroup(dbGroups)
 	if err != nil {
 		msg := map[string]interface{}{
+			"group": dbGroups,
 			"error": err.Error(),
-			"stack": string(debug.Stack()),
 		}
-		App().Log.Error(msg, LogMysqlError)
-		panic(DbError.Error() + ":" + dbGroups)
+		app.Log.errorStack(msg, LogMysqlError)
+		return err
+	}
+	res := app.resource
+	res.dbLock.Lock()
+	old, ok := res.dbGroupCache[dbGroups]
+	res.dbGroupCache[dbGroups] = group
+	res.dbLock.Unlock()
+	if ok {
+		time.AfterFunc(poolCloseDelay, func() {
+			old.retire()
+		})
+	}
+	return nil
+}
+
+//开始事务,事务结束前连接池不会被关闭
+func (group *dbGroup) beginTrans() {
+	group.transLock.Lock()
+	defer group.transLock.Unlock()
+	group.trans++
+}
+
+//事务结束,被替换的连接池在最后一个事务结束后关闭
+func (group *dbGroup) endTrans() {
+	group.transLock.Lock()
+	defer group.transLock.Unlock()
+	group.trans--
+	if group.retired && group.trans <= 0 {
+		group.closeOnce()
+	}
+}
+
+//被新的连接池替换,没有进行中的事务时立即关闭
+func (group *dbGroup) retire() {
+	group.transLock.Lock()
+	defer group.transLock.Unlock()
+	group.retired = true
+	if group.trans <= 0 {
+		group.closeOnce()
+	}
+}
+
+func (group *dbGroup) closeOnce() {
+	if group.closed {
+		return
+	}
+	group.closed = true
+	group.close()
+}
+
+func (app *app) newDbGroup(dbGroups string) (*dbGroup, error) {
+	dbHostConfig := &dbHost{}
+	err := app.Env(dbGroups, dbHostConfig)
+	if err != nil {
+		return nil, err
+	}
+	dbType := dbHostConfig.Type
+	masterConfig := dbHostConfig.Master
+	master, err := newDbPool(dbType, masterConfig)
+	if err != nil {
+		return nil, err
 	}
-	master.SetMaxOpenConns(masterConfig.MaxOpenConn)
-	master.SetMaxIdleConns(masterConfig.MaxIdleConn)
-	master.SetConnMaxLifetime(time.Duration(masterConfig.ConnMaxLifeTime) * time.Second)
-	slavesConfig := dbHostConfig.Slaves
 	slaves := make([]*sql.DB, 0)
-	for _, v := range slavesConfig {
-		bufferDriver := v.Username + ":" +
-			v.Password + "@tcp(" +
-			v.Host + ":" +
-			strconv.Itoa(v.Port) + ")/" +
-			v.DbName + "?charset=" +
-			v.Charset
-		slave, err := sql.Open(dbType, bufferDriver)
+	for _, v := range dbHostConfig.Slaves {
+		slave, err := newDbPool(dbType, v)
 		if err != nil {
-			msg := map[string]interface{}{
-				"error": err.Error(),
-				"stack": string(debug.Stack()),
-			}
-			App().Log.Error(msg, LogMysqlError)
-			panic(DbError.Error() + ":" + dbGroups)
+			return nil, err
 		}
-		slave.SetMaxOpenConns(v.MaxOpenConn)
-		slave.SetMaxIdleConns(v.MaxIdleConn)
-		slave.SetConnMaxLifetime(time.Duration(v.ConnMaxLifeTime) * time.Second)
 		slaves = append(slaves, slave)
 	}
+	//会写入日志,来自密钥的值打码
 	config := &dbConfig{
-		Host:   masterConfig.Host,
+		Host:   maskSecret(masterConfig.Host),
 		Port:   masterConfig.Port,
-		DbName: masterConfig.DbName,
+		DbName: maskSecret(masterConfig.DbName),
 	}
-	dbGroupCache[dbGroups] = &dbGroup{Master: master, Slaves: slaves, Config: config}
-	return dbGroupCache[dbGroups]
+	return &dbGroup{
+		Master:       master,
+		Slaves:       slaves,
+		Config:       config,
+		QueryTimeout: time.Duration(dbHostConfig.QueryTimeout) * time.Millisecond,
+	}, nil
 }
 
-// 关闭数据库连接池
-func closeDB(dbGroups ...string) {
-	if dbGroupCache == nil {
-		return
+func newDbPool(dbType string, hostConfig *dbHostConfig) (*sql.DB, error) {
+	dbDriver := hostConfig.Username + ":" +
+		hostConfig.Password + "@tcp(" +
+		hostConfig.Host + ":" +
+		strconv.Itoa(hostConfig.Port) + ")/" +
+		hostConfig.DbName + "?charset=" +
+		hostConfig.Charset
+	db, err := sql.Open(dbType, dbDriver)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(hostConfig.MaxOpenConn)
+	db.SetMaxIdleConns(hostConfig.MaxIdleConn)
+	db.SetConnMaxLifetime(time.Duration(hostConfig.ConnMaxLifeTime) * time.Second)
+	return db, nil
+}
+
+func (group *dbGroup) close() {
+	_ = group.Master.Close()
+	for _, v := range group.Slaves {
+		_ = v.Close()
 	}
-	if len(dbGroups) > 0 {
-		for _, v := range dbGroups {
-			cache, ok := dbGroupCache[v]
-			if ok {
-				_ = cache.Master.Close()
-				for _, v := range cache.Slaves {
-					_ = v.Close()
-				}
-			}
+}
+
+// 关闭数据库连接池
+// 和重建连接池一样,进行中的事务结束后才真正关闭,之后的查询会重新建立连接池
+func (app *app) closeDB(dbGroups ...string) {
+	res := app.resource
+	res.dbLock.Lock()
+	defer res.dbLock.Unlock()
+	if len(dbGroups) == 0 {
+		for k := range res.dbGroupCache {
+			dbGroups = append(dbGroups, k)
 		}
-	} else {
-		for _, cache := range dbGroupCache {
-			_ = cache.Master.Close()
-			for _, v := range cache.Slaves {
-				_ = v.Close()
-			}
+	}
+	for _, v := range dbGroups {
+		cache, ok := res.dbGroupCache[v]
+		if ok {
+			delete(res.dbGroupCache, v)
+			cache.retire()
 		}
 	}
-	return
 }
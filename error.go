@@ -6,14 +6,29 @@ import "errors"
 //方便将来多语言修改
 
 var ConfigFileError = errors.New("配置文件路径错误")
+var ConfigValidError = errors.New("配置校验错误")
+var ConfigSecretError = errors.New("配置密钥解析错误")
 var CacheError = errors.New("缓存配置错误")
-var EnvironmentError = errors.New("环境变量错误,只能是beta|product|pre|develop")
+var EnvironmentError = errors.New("环境变量错误,只能是beta|product|pre|develop或者通过RegisterEnvironment注册的环境")
 var CounterError = errors.New("计数配置错误")
 var DbError = errors.New("数据库配置错误")
+var FlagError = errors.New("命令行参数错误")
 var HttpError = errors.New("http配置错误")
 var HttpFailError = errors.New("http错误")
+var HookTimeoutError = errors.New("钩子执行超时")
+var CommandError = errors.New("命令不存在")
+var ServerNotRunningError = errors.New("服务未运行")
+var ServerNotCreatedError = errors.New("没有创建server,需要先调用 App().Server(port)")
 var LogPathError = errors.New("log路径设置错误")
+var LogLevelError = errors.New("日志级别错误,只能是debug|info|warn|error|off")
+var LogSinkError = errors.New("日志输出配置错误")
+var LogLayoutError = errors.New("日志路径或格式配置错误")
+var LogAlertError = errors.New("日志告警配置错误")
+var LogQueryError = errors.New("日志查询参数错误")
+var LogStackError = errors.New("日志堆栈配置错误,只能是off|caller|full")
 var MemcachedConfigError = errors.New("memcached配置错误")
 var RedisConfigError = errors.New("redis配置错误")
 var DbAllowError = errors.New("目前还未支持")
 var DbHandleError = errors.New("数据库操作错误")
+var DbBatchKeyError = errors.New("批量更新的数据缺少key")
+var DbUpsertColumnsError = errors.New("upsert冲突时更新的列错误")
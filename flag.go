@@ -1,43 +1,383 @@
 package frame
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 // 获取命令行参数
-// 只支持  go run test.go -environment=pre -appName=api 这种方式
+// 支持 -key=value --key=value 以及不带值的 -key,通过 DefineFlag 声明的非布尔参数还支持 -key value
+// -key value 形式中值以-开头时,只有整数和时间参数的负数(如 -offset -5)作为值,其他的作为下一个参数
+// 声明为 Repeated 的参数可以重复传入,如 -tag=a -tag=b,通过 FlagStrings 获取全部值
+// -- 之后的都作为普通参数,通过 FlagArgs 获取,普通参数同时和原来一样可以通过 GetFlag(参数) 判断是否传入
+// 通过 DefineFlag 声明的参数可以设置默认值、说明、环境变量和是否必填,ParseFlags 检查必填参数并处理 -help
 func GetFlag(key string, defaultVal ...interface{}) interface{} {
-	if flagParams == nil {
-		flagParse()
-	}
-	res, ok := flagParams[key]
+	values, ok := lookupFlag(key)
 	if !ok {
 		if len(defaultVal) > 0 {
 			return defaultVal[0]
 		}
+		if define, ok := getFlagDefine(key); ok && define.Default != nil {
+			return fmt.Sprint(define.Default)
+		}
 		return nil
 	}
-	return res
+	return values[len(values)-1]
 }
 
-var flagParams map[string]interface{}
-var flagOnce sync.Once
+//命令行参数声明
+type Flag struct {
+	Name     string      //参数名,如 environment
+	Default  interface{} //默认值,类型决定参数类型(string,int,bool,time.Duration)
+	Usage    string      //说明,用于 -help 输出
+	Env      string      //命令行没有传入时读取的环境变量
+	Required bool        //是否必填
+	Repeated bool        //是否可以重复传入
+}
+
+var flagParams map[string]interface{} //参数名 => 最后一个值
+var flagValues map[string][]string    //参数名 => 所有值
+var flagArgs []string                 //普通参数
+var flagDefines = make(map[string]*Flag)
+var flagLock sync.RWMutex
+
+//声明命令行参数,同名会被替换
+func DefineFlag(flags ...*Flag) {
+	flagLock.Lock()
+	defer flagLock.Unlock()
+	for _, v := range flags {
+		flagDefines[v.Name] = v
+	}
+	//布尔参数不读取下一个值,声明后需要重新解析
+	flagParams = nil
+}
+
+func getFlagDefine(key string) (*Flag, bool) {
+	flagLock.RLock()
+	defer flagLock.RUnlock()
+	define, ok := flagDefines[key]
+	return define, ok
+}
+
+func (flag *Flag) isBool() bool {
+	_, ok := flag.Default.(bool)
+	return ok
+}
+
+//-key value 中的 value 是否是该参数的值,以-开头的只接受负数
+func (flag *Flag) isValue(arg string) bool {
+	if len(arg) == 0 || arg[0] != '-' {
+		return true
+	}
+	var err error
+	switch flag.Default.(type) {
+	case int:
+		_, err = strconv.Atoi(arg)
+	case time.Duration:
+		_, err = time.ParseDuration(arg)
+	default:
+		return false
+	}
+	return err == nil
+}
+
+func (flag *Flag) typeName() string {
+	switch flag.Default.(type) {
+	case nil:
+		return "string"
+	case time.Duration:
+		return "duration"
+	default:
+		return reflect.TypeOf(flag.Default).String()
+	}
+}
 
 func flagParse() {
-	flagOnce.Do(func() {
-		flagParams = make(map[string]interface{})
-		for k, v := range os.Args {
-			if k > 0 {
-				v = strings.TrimLeft(v, "-")
-				strArr := strings.Split(v, "=")
-				if len(strArr) > 1 {
-					flagParams[strArr[0]] = strArr[1]
-				} else {
-					flagParams[strArr[0]] = ""
+	flagLock.Lock()
+	defer flagLock.Unlock()
+	if flagParams != nil {
+		return
+	}
+	flagParams = make(map[string]interface{})
+	flagValues = make(map[string][]string)
+	flagArgs = make([]string, 0)
+	args := os.Args[1:]
+	for i := 0; i < len(args); i++ {
+		v := args[i]
+		if v == "--" {
+			flagArgs = append(flagArgs, args[i+1:]...)
+			break
+		}
+		if len(v) < 2 || v[0] != '-' {
+			flagArgs = append(flagArgs, v)
+			//兼容原来的方式,普通参数也按 key=value 保存,不覆盖已有的参数
+			key, value := splitFlag(v)
+			if _, ok := flagValues[key]; !ok {
+				flagParams[key] = value
+				flagValues[key] = []string{value}
+			}
+			continue
+		}
+		v = strings.TrimLeft(v, "-")
+		key, value := splitFlag(v)
+		if !strings.Contains(v, "=") {
+			//声明的非布尔参数支持 -key value 形式,下一个不是参数的作为值
+			if define, ok := flagDefines[key]; ok && !define.isBool() {
+				if i+1 < len(args) && define.isValue(args[i+1]) {
+					value = args[i+1]
+					i++
+				}
+			}
+		}
+		flagParams[key] = value
+		flagValues[key] = append(flagValues[key], value)
+	}
+}
+
+func splitFlag(v string) (string, string) {
+	if pos := strings.Index(v, "="); pos >= 0 {
+		return v[:pos], v[pos+1:]
+	}
+	return v, ""
+}
+
+func ensureFlagParsed() {
+	flagLock.RLock()
+	parsed := flagParams != nil
+	flagLock.RUnlock()
+	if !parsed {
+		flagParse()
+	}
+}
+
+//命令行中的值,命令行没有时读取声明的环境变量
+func lookupFlag(key string) ([]string, bool) {
+	ensureFlagParsed()
+	flagLock.RLock()
+	defer flagLock.RUnlock()
+	if values, ok := flagValues[key]; ok {
+		return values, true
+	}
+	if define, ok := flagDefines[key]; ok && define.Env != "" {
+		if value, ok := os.LookupEnv(define.Env); ok {
+			return []string{value}, true
+		}
+	}
+	return nil, false
+}
+
+//所有命令行参数,参数名 => 最后一个值
+func flagAll() map[string]string {
+	ensureFlagParsed()
+	flagLock.RLock()
+	defer flagLock.RUnlock()
+	result := make(map[string]string)
+	for k, v := range flagParams {
+		result[k] = convertToString(v)
+	}
+	return result
+}
+
+//字符串参数
+func FlagString(key string, defaultVal ...string) string {
+	values, ok := lookupFlag(key)
+	if ok {
+		return values[len(values)-1]
+	}
+	if len(defaultVal) > 0 {
+		return defaultVal[0]
+	}
+	if define, ok := getFlagDefine(key); ok && define.Default != nil {
+		return fmt.Sprint(define.Default)
+	}
+	return ""
+}
+
+//整数参数,无法转换时返回默认值
+func FlagInt(key string, defaultVal ...int) int {
+	values, ok := lookupFlag(key)
+	if ok {
+		if i, err := strconv.Atoi(values[len(values)-1]); err == nil {
+			return i
+		}
+	}
+	if len(defaultVal) > 0 {
+		return defaultVal[0]
+	}
+	if define, ok := getFlagDefine(key); ok {
+		if i, ok := define.Default.(int); ok {
+			return i
+		}
+	}
+	return 0
+}
+
+//布尔参数,只传 -key 为true
+func FlagBool(key string, defaultVal ...bool) bool {
+	values, ok := lookupFlag(key)
+	if ok {
+		value := values[len(values)-1]
+		if value == "" {
+			return true
+		}
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	if len(defaultVal) > 0 {
+		return defaultVal[0]
+	}
+	if define, ok := getFlagDefine(key); ok {
+		if b, ok := define.Default.(bool); ok {
+			return b
+		}
+	}
+	return false
+}
+
+//时间参数,如 -timeout=1m30s,无法转换时返回默认值
+func FlagDuration(key string, defaultVal ...time.Duration) time.Duration {
+	values, ok := lookupFlag(key)
+	if ok {
+		if d, err := time.ParseDuration(values[len(values)-1]); err == nil {
+			return d
+		}
+	}
+	if len(defaultVal) > 0 {
+		return defaultVal[0]
+	}
+	if define, ok := getFlagDefine(key); ok {
+		if d, ok := define.Default.(time.Duration); ok {
+			return d
+		}
+	}
+	return 0
+}
+
+//重复传入的参数的所有值
+func FlagStrings(key string) []string {
+	values, ok := lookupFlag(key)
+	if !ok {
+		return make([]string, 0)
+	}
+	result := make([]string, len(values))
+	copy(result, values)
+	return result
+}
+
+//普通参数(不以-开头的)
+func FlagArgs() []string {
+	ensureFlagParsed()
+	flagLock.RLock()
+	defer flagLock.RUnlock()
+	result := make([]string, len(flagArgs))
+	copy(result, flagArgs)
+	return result
+}
+
+//检查声明的参数
+// 传入 -help 或者 -h 时输出帮助并退出
+// 必填参数没有传入、没有声明 Repeated 的参数重复传入或者值无法转换成声明的类型时返回错误
+func ParseFlags() error {
+	if _, ok := lookupFlag("help"); ok {
+		fmt.Print(FlagUsage())
+		os.Exit(0)
+	}
+	if _, ok := lookupFlag("h"); ok {
+		fmt.Print(FlagUsage())
+		os.Exit(0)
+	}
+	flagLock.RLock()
+	defines := make([]*Flag, 0)
+	for _, v := range flagDefines {
+		defines = append(defines, v)
+	}
+	flagLock.RUnlock()
+	messages := make([]string, 0)
+	for _, define := range defines {
+		values, ok := lookupFlag(define.Name)
+		if !ok {
+			if define.Required {
+				messages = append(messages, "-"+define.Name+" 必填")
+			}
+			continue
+		}
+		if len(values) > 1 && !define.Repeated {
+			messages = append(messages, "-"+define.Name+" 不能重复传入")
+		}
+		for _, value := range values {
+			var err error
+			switch define.Default.(type) {
+			case int:
+				_, err = strconv.Atoi(value)
+			case bool:
+				if value != "" {
+					_, err = strconv.ParseBool(value)
 				}
+			case time.Duration:
+				_, err = time.ParseDuration(value)
+			}
+			if err != nil {
+				messages = append(messages, "-"+define.Name+" 需要 "+define.typeName()+" 类型,当前值:"+value)
 			}
 		}
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+	sort.Strings(messages)
+	return errors.New(FlagError.Error() + ":" + strings.Join(messages, "; "))
+}
+
+//所有声明参数的帮助信息
+func FlagUsage() string {
+	flagLock.RLock()
+	defines := make([]*Flag, 0)
+	for _, v := range flagDefines {
+		defines = append(defines, v)
+	}
+	flagLock.RUnlock()
+	return "用法: " + filepath.Base(os.Args[0]) + " [参数]\n" + flagUsage(defines)
+}
+
+//参数列表的帮助信息,按参数名排序
+func flagUsage(defines []*Flag) string {
+	defines = append(make([]*Flag, 0, len(defines)), defines...)
+	sort.Slice(defines, func(i, j int) bool {
+		return defines[i].Name < defines[j].Name
 	})
-}
\ No newline at end of file
+	usage := ""
+	for _, define := range defines {
+		usage += "  -" + define.Name
+		if !define.isBool() {
+			usage += " " + define.typeName()
+		}
+		usage += "\n    \t" + define.Usage
+		extra := make([]string, 0)
+		if define.Default != nil && !(define.isBool() && !define.Default.(bool)) {
+			extra = append(extra, "默认 "+fmt.Sprint(define.Default))
+		}
+		if define.Env != "" {
+			extra = append(extra, "环境变量 "+define.Env)
+		}
+		if define.Required {
+			extra = append(extra, "必填")
+		}
+		if define.Repeated {
+			extra = append(extra, "可重复")
+		}
+		if len(extra) > 0 {
+			usage += " (" + strings.Join(extra, ", ") + ")"
+		}
+		usage += "\n"
+	}
+	return usage
+}
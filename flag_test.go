@@ -0,0 +1,144 @@
+package frame
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFlagParse(t *testing.T) {
+	DefineFlag(
+		&Flag{Name: "test-offset", Default: 0},
+		&Flag{Name: "test-name", Default: "default"},
+		&Flag{Name: "test-verbose", Default: false},
+		&Flag{Name: "test-timeout", Default: time.Second},
+		&Flag{Name: "test-tag", Repeated: true},
+	)
+	tests := []struct {
+		name      string
+		args      []string
+		ints      map[string]int
+		strs      map[string]string
+		bools     map[string]bool
+		durations map[string]time.Duration
+		repeated  map[string][]string
+		rest      []string
+		parseErr  bool
+	}{
+		{
+			name: "negative int",
+			args: []string{"-test-offset", "-5", "pos"},
+			ints: map[string]int{"test-offset": -5},
+			rest: []string{"pos"},
+		},
+		{
+			name: "negative int with equal sign",
+			args: []string{"-test-offset=-5"},
+			ints: map[string]int{"test-offset": -5},
+			rest: []string{},
+		},
+		{
+			name:      "negative duration",
+			args:      []string{"--test-timeout", "-1s"},
+			durations: map[string]time.Duration{"test-timeout": -time.Second},
+			rest:      []string{},
+		},
+		{
+			name: "key value",
+			args: []string{"-test-name", "value", "pos"},
+			strs: map[string]string{"test-name": "value"},
+			rest: []string{"pos"},
+		},
+		{
+			name:  "bool does not take value",
+			args:  []string{"-test-verbose", "pos"},
+			bools: map[string]bool{"test-verbose": true},
+			rest:  []string{"pos"},
+		},
+		{
+			name:  "flag after string flag",
+			args:  []string{"-test-name", "-test-verbose"},
+			strs:  map[string]string{"test-name": ""},
+			bools: map[string]bool{"test-verbose": true},
+			rest:  []string{},
+		},
+		{
+			name: "undeclared flag does not take value",
+			args: []string{"-test-undeclared", "value"},
+			strs: map[string]string{"test-undeclared": "", "value": ""},
+			rest: []string{"value"},
+		},
+		{
+			name: "positional key value",
+			args: []string{"k=v"},
+			strs: map[string]string{"k": "v", "test-name": "default"},
+			rest: []string{"k=v"},
+		},
+		{
+			name:     "repeated",
+			args:     []string{"-test-tag=a", "--test-tag", "b"},
+			repeated: map[string][]string{"test-tag": {"a", "b"}},
+			rest:     []string{},
+		},
+		{
+			name: "double dash",
+			args: []string{"--", "-test-offset", "1"},
+			ints: map[string]int{"test-offset": 0},
+			rest: []string{"-test-offset", "1"},
+		},
+		{
+			name:     "invalid int",
+			args:     []string{"-test-offset=x"},
+			parseErr: true,
+		},
+		{
+			name:     "not repeated",
+			args:     []string{"-test-name=a", "-test-name=b"},
+			parseErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			restore := setTestArgs(nil, test.args)
+			defer restore()
+			err := ParseFlags()
+			if test.parseErr {
+				if err == nil {
+					t.Fatal("需要返回错误")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range test.ints {
+				if got := FlagInt(k); got != v {
+					t.Fatalf("-%s 为 %d,需要 %d", k, got, v)
+				}
+			}
+			for k, v := range test.strs {
+				if got := FlagString(k); got != v {
+					t.Fatalf("-%s 为 %q,需要 %q", k, got, v)
+				}
+			}
+			for k, v := range test.bools {
+				if got := FlagBool(k); got != v {
+					t.Fatalf("-%s 为 %v,需要 %v", k, got, v)
+				}
+			}
+			for k, v := range test.durations {
+				if got := FlagDuration(k); got != v {
+					t.Fatalf("-%s 为 %v,需要 %v", k, got, v)
+				}
+			}
+			for k, v := range test.repeated {
+				if got := FlagStrings(k); !reflect.DeepEqual(got, v) {
+					t.Fatalf("-%s 为 %v,需要 %v", k, got, v)
+				}
+			}
+			if got := FlagArgs(); !reflect.DeepEqual(got, test.rest) {
+				t.Fatalf("普通参数 %v,需要 %v", got, test.rest)
+			}
+		})
+	}
+}
@@ -153,6 +153,16 @@ func jsonMarshal(t interface{}) ([]byte, error) {
 	return buffer.Bytes(), err
 }
 
+//判断字符串是否在切片中
+func inStringSlice(str string, arr []string) bool {
+	for _, v := range arr {
+		if v == str {
+			return true
+		}
+	}
+	return false
+}
+
 // 判断文件夹是否存在
 func pathExists(path string) (bool, error) {
 	_, err := os.Stat(path)
@@ -7,7 +7,6 @@ import (
 	"net/http"
 	"net/url"
 	"reflect"
-	"runtime/debug"
 	"strings"
 	"sync"
 	"time"
@@ -27,6 +26,15 @@ type Curl struct {
 	body           string
 	requestInfo    *requestInfo
 	ClientGroup    string //client配置 如 curl/client_default
+	App            *app   //所属应用,为空使用默认应用
+	ctx            context.Context
+	quiet          bool //不记录报错日志,发送告警时使用,避免告警失败再次触发告警
+}
+
+//设置上下文,报错日志带上上下文中的字段
+func (curl *Curl) WithContext(ctx context.Context) *Curl {
+	curl.ctx = ctx
+	return curl
 }
 
 func (curl *Curl) Get(uri string, requestMapHeaders ...map[string]interface{}) (string, error) {
@@ -65,6 +73,29 @@ func (curl *Curl) PostByMultiPart(uri string, requestMapHeaders ...map[string]in
 	return curl.request(http.MethodPost, uri, requestMap, header, true)
 }
 
+//以json格式POST提交
+func (curl *Curl) PostJson(uri string, data interface{}, headers ...map[string]interface{}) (string, error) {
+	header := make(map[string]interface{}, 0)
+	if len(headers) > 0 {
+		for k, v := range headers[0] {
+			header[k] = v
+		}
+	}
+	header["Content-Type"] = "application/json"
+	curl.resetBefore()
+	body, err := jsonMarshal(data)
+	if err != nil {
+		curlErrorHandle(err, curl)
+		return "", err
+	}
+	remoteUrl, err := url.Parse(uri)
+	if err != nil {
+		curlErrorHandle(err, curl)
+		return "", err
+	}
+	return curl.send(http.MethodPost, remoteUrl, string(body), header)
+}
+
 func (curl *Curl) HttpCode() int {
 	return curl.httpCode
 }
@@ -77,10 +108,8 @@ func (curl *Curl) request(method string, uri string, requestMap map[string]inter
 	curl.resetBefore()
 	//构造url参数
 	remoteUrl, err := url.Parse(uri)
-	defer func() {
-		curlErrorHandle(err, curl)
-	}()
 	if err != nil {
+		curlErrorHandle(err, curl)
 		return "", err
 	}
 	bodyString := ""
@@ -113,7 +142,15 @@ func (curl *Curl) request(method string, uri string, requestMap map[string]inter
 			bodyString = string(mJson)
 		}
 	}
+	return curl.send(method, remoteUrl, bodyString, header)
+}
 
+//发送请求,报错时记录日志
+func (curl *Curl) send(method string, remoteUrl *url.URL, bodyString string, header map[string]interface{}) (string, error) {
+	var err error
+	defer func() {
+		curlErrorHandle(err, curl)
+	}()
 	req, err := http.NewRequest(method, remoteUrl.String(), strings.NewReader(bodyString))
 	if err != nil {
 		return "", err
@@ -131,7 +168,7 @@ func (curl *Curl) request(method string, uri string, requestMap map[string]inter
 			if curl.ClientGroup == "" {
 				curl.ClientGroup = "curl/client_default"
 			}
-			curl.httpClient = getHttpClient(curl.ClientGroup)
+			curl.httpClient = getApp(curl.App).getHttpClient(curl.ClientGroup)
 		})
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second) //后发送报文的最长时间,文件上传时可能很长 超时客户端就自己取消报504
@@ -167,15 +204,14 @@ func (curl *Curl) resetBefore() {
 }
 
 func curlErrorHandle(err error, curl *Curl) {
-	if err != nil {
+	if err != nil && !curl.quiet {
 		msg := map[string]interface{}{
 			"request":   curl.requestInfo,
 			"http_code": curl.httpCode,
 			"header":    curl.header,
 			"body":      curl.body,
 			"error":     err.Error(),
-			"stack":     string(debug.Stack()),
 		}
-		App().Log.Error(msg, LogCurlError)
+		contextLog(curl.App, curl.ctx).errorStack(msg, LogCurlError)
 	}
 }
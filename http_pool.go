@@ -4,31 +4,22 @@ import (
 	"net"
 	"net/http"
 	"net/url"
-	"runtime/debug"
 	"strconv"
-	"sync"
 	"time"
 )
 
-var httpLock sync.Mutex
-
 type curlClientConfig struct {
 	KeepAlive          bool   `toml:"keepAlive"`
-	TimeOut            int    `toml:"timeout"`
-	MaxIdleConn        int    `toml:"maxIdleConns"`
-	MaxIdleConnPerHost int    `toml:"maxIdleConnsPerHost"`
-	IdleConnTimeout    int    `toml:"idleConnTimeout"`
+	TimeOut            int    `toml:"timeout" validate:"min=0"`
+	MaxIdleConn        int    `toml:"maxIdleConns" validate:"min=0"`
+	MaxIdleConnPerHost int    `toml:"maxIdleConnsPerHost" validate:"min=0"`
+	IdleConnTimeout    int    `toml:"idleConnTimeout" validate:"min=0"`
 	ProxyUrl           string `toml:"proxyUrl"`
 }
 
-var httpClientPool map[string]*http.Client
-
-func getHttpClient(clientGroup string) *http.Client {
-	if httpClientPool == nil {
-		httpClientPool = make(map[string]*http.Client)
-	}
+func (app *app) getHttpClient(clientGroup string) *http.Client {
 	clientConfig := &curlClientConfig{}
-	err := App().Env(clientGroup, clientConfig)
+	err := app.Env(clientGroup, clientConfig)
 	if err != nil {
 		panic(HttpError.Error() + ":" + err.Error())
 	}
@@ -47,12 +38,18 @@ func getHttpClient(clientGroup string) *http.Client {
 	}
 	cacheKey := keepAliveStr + strconv.Itoa(timeout) + strconv.Itoa(maxIdleConns) +
 		strconv.Itoa(maxIdleConnsPerHost) + strconv.Itoa(idleConnTimeout) + proxyUrl
-	cache, ok := httpClientPool[cacheKey]
+	res := app.resource
+	res.httpLock.RLock()
+	cache, ok := res.httpClientPool[cacheKey]
+	res.httpLock.RUnlock()
 	if ok {
 		return cache
 	}
-	httpLock.Lock()
-	defer httpLock.Unlock()
+	res.httpLock.Lock()
+	defer res.httpLock.Unlock()
+	if cache, ok := res.httpClientPool[cacheKey]; ok {
+		return cache
+	}
 	var clientObj *http.Client
 	if proxyUrl != "" {
 		proxy, err := url.Parse(proxyUrl)
@@ -60,9 +57,8 @@ func getHttpClient(clientGroup string) *http.Client {
 			//报错
 			msg := map[string]interface{}{
 				"error": err.Error(),
-				"stack": string(debug.Stack()),
 			}
-			App().Log.Error(msg, LogClientError)
+			app.Log.errorStack(msg, LogClientError)
 			return nil
 		}
 		clientObj = &http.Client{
@@ -94,16 +90,15 @@ func getHttpClient(clientGroup string) *http.Client {
 			Timeout: time.Duration(timeout) * time.Second, //处理单个请求最长时间
 		}
 	}
-	httpClientPool[cacheKey] = clientObj
-	return httpClientPool[cacheKey]
+	res.httpClientPool[cacheKey] = clientObj
+	return clientObj
 }
 
 //关闭连接池中所有闲置连接
-func closeHttpClient() {
-	if httpClientPool == nil {
-		return
-	}
-	for _, v := range httpClientPool {
+func (app *app) closeHttpClient() {
+	app.resource.httpLock.RLock()
+	defer app.resource.httpLock.RUnlock()
+	for _, v := range app.resource.httpClientPool {
 		v.CloseIdleConnections()
 	}
 }
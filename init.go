@@ -1,7 +1,6 @@
 package frame
 
 import (
-	"runtime/debug"
 	"time"
 )
 
@@ -20,21 +19,19 @@ func init() {
 		nowTime := int(time.Now().Unix())
 		runSecond := nowTime - mysql.BeginTime
 		if runSecond >= 2 {
-			App().Log.Warn(map[string]interface{}{
+			mysql.Log().warnStack(map[string]interface{}{
 				"sql":        mysql.GetSql(),
 				"run_second": runSecond,
 				"config":     mysql.DbGroup.Config,
-				"stack":      string(debug.Stack()),
 			}, LogMysqlSlow)
 		}
 	})
 	//注册mysql执行中的报错,支持重载
 	SetMysqlErrorExecute(func(mysql *Mysql, err error) {
-		App().Log.Error(map[string]interface{}{
+		mysql.Log().errorStack(map[string]interface{}{
 			"sql":    mysql.GetSql(),
 			"config": mysql.DbGroup.Config,
 			"error":  err.Error(),
-			"stack":  string(debug.Stack()),
 		}, LogMysqlError)
 	})
 }
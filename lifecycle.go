@@ -0,0 +1,200 @@
+package frame
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 启动和关闭钩子
+// 用法:
+//	frame.App().OnStart("queue_worker", frame.HookPriorityDefault, func() error {
+//		//启动队列消费
+//		return nil
+//	})
+//	frame.App().OnShutdown("flush_counter", frame.HookPriorityDefault, func() error {
+//		//写入缓冲的计数
+//		return nil
+//	}, 3*time.Second)
+// 按优先级从小到大依次执行,优先级相同按注册顺序执行
+// web服务在启动监听前执行启动钩子,收到退出信号后执行关闭钩子
+// 脚本需要自己调用 frame.App().Start() 和 defer frame.App().Shutdown()
+// 连接池的关闭也是关闭钩子,优先级为 HookPriorityResource 之后,业务钩子默认在连接池关闭前执行
+
+//默认优先级
+const HookPriorityDefault = 100
+
+//连接池关闭的优先级,依次关闭 db http memcached redis
+const HookPriorityResource = 1000
+
+//单个钩子默认超时时间
+const hookTimeout = 10 * time.Second
+
+type lifecycleHook struct {
+	name     string
+	priority int
+	timeout  time.Duration
+	f        func() error
+}
+
+type lifecycle struct {
+	lock          sync.Mutex
+	startHooks    []*lifecycleHook
+	shutdownHooks []*lifecycleHook
+	shutdown      bool //关闭钩子只执行一次
+}
+
+func newLifecycle(app *app) *lifecycle {
+	lifecycle := &lifecycle{
+		startHooks:    make([]*lifecycleHook, 0),
+		shutdownHooks: make([]*lifecycleHook, 0),
+	}
+	resources := []struct {
+		name string
+		f    func()
+	}{
+		{"close_db", func() { app.closeDB() }},
+		{"close_http_client", app.closeHttpClient},
+		{"close_memcached", app.closeMemcachedPool},
+		{"close_redis", app.closeRedis},
+	}
+	for k, v := range resources {
+		f := v.f
+		lifecycle.shutdownHooks = append(lifecycle.shutdownHooks, &lifecycleHook{
+			name:     v.name,
+			priority: HookPriorityResource + k,
+			timeout:  hookTimeout,
+			f: func() error {
+				f()
+				return nil
+			},
+		})
+	}
+	return lifecycle
+}
+
+//注册启动钩子
+// name 钩子名称,用于日志
+// priority 优先级,越小越先执行
+// f 返回错误时停止启动
+// timeout 超时时间,默认10秒
+func (app *app) OnStart(name string, priority int, f func() error, timeout ...time.Duration) {
+	hook := newLifecycleHook(name, priority, f, timeout...)
+	app.lifecycle.lock.Lock()
+	defer app.lifecycle.lock.Unlock()
+	app.lifecycle.startHooks = append(app.lifecycle.startHooks, hook)
+}
+
+//注册关闭钩子
+// name 钩子名称,用于日志
+// priority 优先级,越小越先执行,需要在连接池关闭前执行的小于 HookPriorityResource
+// f 返回错误时记录日志,继续执行后面的钩子
+// timeout 超时时间,默认10秒
+func (app *app) OnShutdown(name string, priority int, f func() error, timeout ...time.Duration) {
+	hook := newLifecycleHook(name, priority, f, timeout...)
+	app.lifecycle.lock.Lock()
+	defer app.lifecycle.lock.Unlock()
+	app.lifecycle.shutdownHooks = append(app.lifecycle.shutdownHooks, hook)
+}
+
+func newLifecycleHook(name string, priority int, f func() error, timeout ...time.Duration) *lifecycleHook {
+	hook := &lifecycleHook{name: name, priority: priority, timeout: hookTimeout, f: f}
+	if len(timeout) > 0 && timeout[0] > 0 {
+		hook.timeout = timeout[0]
+	}
+	return hook
+}
+
+//依次执行启动钩子,有钩子报错或者超时停止执行并返回错误
+func (app *app) Start() error {
+	app.lifecycle.lock.Lock()
+	hooks := sortHooks(app.lifecycle.startHooks)
+	app.lifecycle.lock.Unlock()
+	for _, hook := range hooks {
+		if err := app.runHook("start", hook); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//依次执行关闭钩子,报错或者超时的记录日志后继续执行,返回所有错误
+// 多次调用只执行一次
+func (app *app) Shutdown() error {
+	app.lifecycle.lock.Lock()
+	if app.lifecycle.shutdown {
+		app.lifecycle.lock.Unlock()
+		return nil
+	}
+	app.lifecycle.shutdown = true
+	hooks := sortHooks(app.lifecycle.shutdownHooks)
+	app.lifecycle.lock.Unlock()
+	messages := make([]string, 0)
+	for _, hook := range hooks {
+		if err := app.runHook("shutdown", hook); err != nil {
+			messages = append(messages, err.Error())
+		}
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(messages, "; "))
+}
+
+//按优先级排序,相同优先级保持注册顺序
+func sortHooks(hooks []*lifecycleHook) []*lifecycleHook {
+	result := make([]*lifecycleHook, len(hooks))
+	copy(result, hooks)
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].priority < result[j].priority
+	})
+	return result
+}
+
+//执行单个钩子,超时不等待钩子结束
+func (app *app) runHook(stage string, hook *lifecycleHook) error {
+	done := make(chan error, 1)
+	//panic时钩子协程中的堆栈
+	stack := make(chan string, 1)
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				stack <- string(debug.Stack())
+				done <- fmt.Errorf("%v", err)
+			}
+		}()
+		done <- hook.f()
+	}()
+	var err error
+	timer := time.NewTimer(hook.timeout)
+	defer timer.Stop()
+	select {
+	case err = <-done:
+	case <-timer.C:
+		err = HookTimeoutError
+	}
+	if err == nil {
+		return nil
+	}
+	err = errors.New(stage + ":" + hook.name + ":" + err.Error())
+	msg := map[string]interface{}{
+		"stage":    stage,
+		"hook":     hook.name,
+		"priority": hook.priority,
+		"error":    err.Error(),
+	}
+	select {
+	case msg["stack"] = <-stack:
+	default:
+	}
+	if app.Log == nil {
+		fmt.Println(msg)
+	} else {
+		app.Log.Error(msg, LogLifecycleError)
+	}
+	return err
+}
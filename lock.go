@@ -17,12 +17,13 @@ type Lock struct {
 	redisOnce sync.Once
 	KeyPrefix string
 	LockTime  int
+	App       *app //所属应用,为空使用默认应用
 }
 
 func (lock *Lock) getRedis() *Redis {
 	if lock.redis == nil {
 		lock.redisOnce.Do(func() {
-			lock.redis = &Redis{GroupName: lock.GroupName}
+			lock.redis = &Redis{GroupName: lock.GroupName, App: lock.App}
 		})
 	}
 	return lock.redis
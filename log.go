@@ -3,29 +3,73 @@ package frame
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type logTpl struct {
-	Level string      `json:"level"`
-	Msg   interface{} `json:"msg"`
-	Time  string      `json:"time"`
-	Type  string      `json:"type"`
-	Pid   int         `json:"pid"`
-}
-type logBehaviorTpl struct {
-	Msg  interface{} `json:"msg"`
-	Time string      `json:"time"`
-	Path string      `json:"path"`
+	Level  string                 `json:"level"`
+	Msg    interface{}            `json:"msg"`
+	Time   string                 `json:"time"`
+	Type   string                 `json:"type"`
+	Pid    int                    `json:"pid"`
+	Fields map[string]interface{} `json:"fields,omitempty"`
 }
 
 var errorHandle func(msg string, logType string, logLevel string)
 
 //日志记录结构体
 // 通过frame.App().Log 进行使用
+// With 生成的子日志共用 logCore,只是附加的字段不同
 type log struct {
-	path string
+	*logCore
+	fields map[string]interface{} //附加到每条日志的字段
+}
+
+type logCore struct {
+	path      string
+	config    *logConfig
+	janitor   *logJanitor
+	writer    *logWriter //异步写入,同步写入时为空
+	sinks     []*logSinkRoute
+	layout    *logLayout      //文件路径和内容格式
+	dedup     *logDedup       //重复日志抑制,没有配置时为空
+	alert     *logAlert       //告警
+	behavior  *log            //单独的行为日志,没有配置 behaviorPath 时为空
+	levels    atomic.Value    //*logLevelConfig 级别过滤,配置和运行时设置合并后的结果
+	levelLock sync.Mutex      //修改级别时加锁
+	levelBase *logLevelConfig //app.toml 中的级别
+	levelSet  *logLevelConfig //运行时通过 SetLevel/SetTypeLevel 设置的级别,level 为-1时没有设置
+}
+
+//app.toml 中的 [log] 配置
+type logConfig struct {
+	Path            string             `toml:"path"`
+	BehaviorPath    string             `toml:"behaviorPath"`
+	KeepDays        int                `toml:"keepDays" validate:"min=0"`                        //保留天数,0不删除
+	MaxSize         int                `toml:"maxSize" validate:"min=0"`                         //每个级别目录最大容量(MB),0不限制
+	Compress        bool               `toml:"compress"`                                         //是否压缩已经结束的小时日志
+	JanitorInterval int                `toml:"janitorInterval" validate:"min=0"`                 //清理间隔(秒),默认600
+	SyncWrite       bool               `toml:"syncWrite"`                                        //同步写入,默认异步写入
+	BufferSize      int                `toml:"bufferSize" validate:"min=0"`                      //异步写入缓冲的行数,默认10000,缓冲满时丢弃
+	FlushInterval   int                `toml:"flushInterval" validate:"min=0"`                   //异步写入刷盘间隔(毫秒),默认1000
+	Level           string             `toml:"level" validate:"oneof=debug info warn error off"` //最低级别,默认debug
+	Types           map[string]string  `toml:"types"`                                            //日志类型 => 最低级别
+	Sinks           []*LogSinkConfig   `toml:"sinks"`                                            //输出,默认只写文件
+	PathTemplate    string             `toml:"pathTemplate"`                                     //文件路径模板,默认 {level}/{date}/{hour}.log
+	Prefix          string             `toml:"prefix" validate:"oneof=app host app/host"`        //path下增加的 应用名/主机名 目录
+	Format          string             `toml:"format" validate:"oneof=json logfmt text"`         //内容格式,默认json
+	TimeFormat      string             `toml:"timeFormat"`                                       //时间格式,rfc3339或go的时间格式,默认 2006-01-02 15:04:05
+	Timezone        string             `toml:"timezone"`                                         //时区,默认本地时区
+	Dedup           *logDedupConfig    `toml:"dedup"`                                            //重复日志抑制
+	Behavior        *logBehaviorConfig `toml:"behavior"`                                         //行为日志的切分和清理
+	Alerts          []*AlertRule       `toml:"alerts"`                                           //告警规则
+	AlertQueue      int                `toml:"alertQueue" validate:"min=0"`                      //告警队列长度,默认1000
+	Stack           string             `toml:"stack" validate:"oneof=off caller full"`           //报错日志的堆栈,默认full
+	StackLevels     map[string]string  `toml:"stackLevels"`                                      //级别 => 堆栈策略
+	StackTypes      map[string]string  `toml:"stackTypes"`                                       //日志类型 => 堆栈策略,优先于级别
 }
 
 //定义几种错误级别
@@ -35,26 +79,78 @@ const LogTypeInfo = "info"
 const LogTypeDebug = "debug"
 const LogTypeBehavior = "behavior" //行为日志
 
-var myLog *log
-var logOnce sync.Once
-
-func getLog() *log {
-	logOnce.Do(func() {
-		if myLog == nil {
-			myLog = &log{}
-			log := &struct {
-				Log struct {
-					Path         string `toml:"path"`
-					BehaviorPath string `toml:"behaviorPath"`
-				} `toml:"log"`
-			}{}
-			err := App().Env("app", log)
-			if err != nil {
-				panic(LogPathError)
-			}
-			myLog.path = log.Log.Path
+//读取应用配置中的日志目录
+func (app *app) newLog() *log {
+	myLog := &log{logCore: &logCore{}}
+	log := &struct {
+		Log *logConfig `toml:"log"`
+	}{Log: &logConfig{}}
+	err := app.Env("app", log)
+	if err != nil {
+		panic(LogPathError)
+	}
+	myLog.path = log.Log.Path
+	myLog.config = log.Log
+	levelConfig, err := newLogLevelConfig(log.Log.Level, log.Log.Types)
+	if err != nil {
+		panic(err)
+	}
+	myLog.levelBase = levelConfig
+	myLog.levels.Store(levelConfig)
+	if err := checkStackConfig(myLog.config); err != nil {
+		panic(err)
+	}
+	myLog.layout, err = newLogLayout(myLog.config, app.AppName)
+	if err != nil {
+		panic(err)
+	}
+	if !myLog.config.SyncWrite && myLog.config.hasFileSink() {
+		myLog.writer = newLogWriter(myLog)
+		myLog.writer.start()
+	}
+	myLog.sinks, err = myLog.newSinks(app.AppName)
+	if err != nil {
+		panic(err)
+	}
+	//其他关闭钩子可能会写日志,最后关闭
+	app.OnShutdown("log_writer", HookPriorityResource+200, func() error {
+		if myLog.writer != nil {
+			myLog.writer.stop()
 		}
+		myLog.closeSinks()
+		return nil
 	})
+	if myLog.config.BehaviorPath != "" {
+		myLog.behavior = app.newBehaviorLog(myLog.config, myLog.layout)
+	}
+	myLog.alert, err = newLogAlert(app, myLog.config)
+	if err != nil {
+		panic(err)
+	}
+	myLog.alert.start()
+	//重复日志的汇总也会告警,在其之后关闭
+	app.OnShutdown("log_alert", HookPriorityResource+180, func() error {
+		myLog.alert.stop()
+		return nil
+	})
+	//配置了窗口时抑制重复日志,在关闭写入前写入汇总
+	if myLog.config.Dedup != nil && myLog.config.Dedup.Window > 0 {
+		myLog.dedup = newLogDedup(myLog, myLog.config.Dedup)
+		myLog.dedup.start()
+		app.OnShutdown("log_dedup", HookPriorityResource+150, func() error {
+			myLog.dedup.stop()
+			return nil
+		})
+	}
+	//配置了保留规则时后台清理
+	if myLog.config.KeepDays > 0 || myLog.config.MaxSize > 0 || myLog.config.Compress {
+		myLog.janitor = newLogJanitor(myLog)
+		myLog.janitor.start()
+		app.OnShutdown("log_janitor", HookPriorityResource+100, func() error {
+			myLog.janitor.stop()
+			return nil
+		})
+	}
 	return myLog
 }
 
@@ -62,7 +158,6 @@ func (myLog *log) Error(msg interface{}, contentName string) {
 	tpl := &logTpl{
 		Level: LogTypeError,
 		Msg:   msg,
-		Time:  myLog.getLogTime(),
 		Type:  contentName,
 	}
 	myLog.log(tpl)
@@ -71,7 +166,6 @@ func (myLog *log) Info(msg interface{}, contentName string) {
 	tpl := &logTpl{
 		Level: LogTypeInfo,
 		Msg:   msg,
-		Time:  myLog.getLogTime(),
 		Type:  contentName,
 	}
 	myLog.log(tpl)
@@ -80,7 +174,6 @@ func (myLog *log) Debug(msg interface{}, contentName string) {
 	tpl := &logTpl{
 		Level: LogTypeDebug,
 		Msg:   msg,
-		Time:  myLog.getLogTime(),
 		Type:  contentName,
 	}
 	myLog.log(tpl)
@@ -89,105 +182,79 @@ func (myLog *log) Warn(msg interface{}, contentName string) {
 	tpl := &logTpl{
 		Level: LogTypeWarn,
 		Msg:   msg,
-		Time:  myLog.getLogTime(),
 		Type:  contentName,
 	}
 	myLog.log(tpl)
 }
+//行为日志,和 Track 使用同样的事件格式,contentName 为事件名
+// msg 为 map[string]interface{} 时作为属性,其他的放在属性的 msg 中
 func (myLog *log) Behavior(msg interface{}, contentName string) {
-	tpl := &logTpl{
-		Level: LogTypeBehavior,
-		Msg:   msg,
-		Time:  myLog.getLogTime(),
-		Type:  contentName,
-	}
-	myLog.log(tpl)
+	myLog.Track(contentName, "", behaviorProperties(msg))
 }
 func (myLog *log) GetPath() string {
 	return myLog.path
 }
 func (myLog *log) log(tpl *logTpl) {
-	var logMsg string
-	if tpl.Level == LogTypeBehavior {
-		behaviorTpl := &logBehaviorTpl{
-			Msg:  tpl.Msg,
-			Time: tpl.Time,
-			Path: tpl.Type,
-		}
-		switch behaviorTpl.Msg.(type) {
-		case error:
-			tplMsg := fmt.Sprintf("%s", behaviorTpl.Msg)
-			behaviorTpl.Msg = tplMsg
-		default:
+	if now, ok := myLog.accept(tpl); ok {
+		myLog.output(tpl, now)
+	}
+}
 
-		}
-		msg, err := jsonMarshal(behaviorTpl)
-		logMsg = string(msg)
-		if err != nil {
-			fmt.Println(err)
-			return
-		}
-	} else {
-		tpl.Pid = os.Getpid()
-		switch tpl.Msg.(type) {
-		case error:
-			tplMsg := fmt.Sprintf("%s", tpl.Msg)
-			tpl.Msg = tplMsg
-		default:
+//级别和重复检查,返回是否需要记录和记录时间
+func (myLog *log) accept(tpl *logTpl) (time.Time, bool) {
+	if !myLog.enabled(tpl.Level, tpl.Type) {
+		return time.Time{}, false
+	}
+	now := myLog.layout.now()
+	if myLog.dedup != nil && !myLog.dedup.allow(tpl, now) {
+		return now, false
+	}
+	return now, true
+}
+
+//格式化并写入各个输出
+func (myLog *log) output(tpl *logTpl, now time.Time) {
+	tpl.Time = myLog.layout.formatTime(now)
+	tpl.Pid = os.Getpid()
+	tpl.Fields = myLog.fields
+	switch tpl.Msg.(type) {
+	case error:
+		tplMsg := fmt.Sprintf("%s", tpl.Msg)
+		tpl.Msg = tplMsg
+	default:
 
-		}
-		msg, err := jsonMarshal(tpl)
-		logMsg = string(msg)
-		if err != nil {
-			fmt.Println(err)
-			return
-		}
 	}
-	now := time.Now()
-	timePath := fmt.Sprintf("%d%d%d", now.Year(), now.Month(), now.Day())
-	logPath:=myLog.path+"/" + tpl.Level+"/"+timePath
-	exists,err:=pathExists(myLog.path+"/" + tpl.Level)
-	if err!=nil{
-		fmt.Println("判断文件夹是否存在失败")
+	logMsg, err := myLog.layout.formatLine(tpl)
+	if err != nil {
+		fmt.Println(err)
 		return
 	}
-	if !exists{
-		err=os.Mkdir(myLog.path+"/" + tpl.Level, os.ModePerm)
-		if err != nil {
-			fmt.Println(err)
-			return
-		}
+	entry := &LogEntry{Level: tpl.Level, Type: tpl.Type, Time: now, Line: logMsg}
+	myLog.writeSinks(entry)
+	if myLog.alert != nil {
+		myLog.alert.dispatch(entry)
 	}
-	exists,err=pathExists(logPath)
-	if err!=nil{
-		fmt.Println("判断文件夹是否存在失败")
-		return
+	//增加一个对外方法可以进行其他操作
+	if errorHandle != nil {
+		errorHandle(logMsg, tpl.Type, tpl.Level)
 	}
-	if !exists{
-		err=os.Mkdir(logPath, os.ModePerm)
-		if err != nil {
-			fmt.Println(err)
-			return
-		}
+}
+
+//直接写入文件,没有开启异步写入或者已经关闭时使用
+func (myLog *log) writeSync(level string, now time.Time, logMsg string) {
+	filePath := myLog.layout.filePath(level, now)
+	err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm)
+	if err != nil {
+		fmt.Println(err)
+		return
 	}
-	logFile := logPath+"/"+ fmt.Sprintf("%d",now.Hour()) + ".log"
-	file, err := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0777)
+	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0777)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
 	defer file.Close()
 	_, _ = file.Write([]byte(logMsg))
-	//增加一个对外方法可以进行其他操作
-	if errorHandle != nil && tpl.Level != LogTypeBehavior {
-		errorHandle(logMsg, tpl.Type, tpl.Level)
-	}
-}
-
-func (myLog *log) getLogTime() string {
-	now := time.Now()
-	logTime := fmt.Sprintf("%d-%d-%d %d:%d:%d", now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), now.Second())
-	return logTime
 }
 
 //设置错误处理
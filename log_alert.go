@@ -0,0 +1,320 @@
+package frame
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 日志告警
+// 日志写入后按规则匹配,匹配的日志放入有界队列,由后台协程调用处理函数,队列满时丢弃并计数
+// 同一规则同一日志类型在 throttle 秒内只告警一次,期间被节流的条数随下一次告警带上
+// app.toml 中配置:
+//	[log]
+//	alertQueue = 1000                 #告警队列长度,默认1000
+//	[[log.alerts]]
+//	name = "chat"
+//	handler = "webhook"               #内置webhook,以json POST到url
+//	url = "http://chat/robot/send"
+//	clientGroup = "curl/client_default"
+//	levels = ["error"]                #为空全部级别
+//	types = ["redis_error", "mysql_error"] #为空全部类型
+//	throttle = 60
+//	retry = 2                         #webhook失败后的重试次数,默认不重试
+//	retryInterval = 500               #重试间隔(毫秒),默认500
+//	[[log.alerts]]
+//	name = "metrics"
+//	handler = "error_counter"         #RegisterAlertHandler 注册的处理函数
+// 代码中添加:
+//	frame.App().Log.AddAlert(&frame.AlertRule{Name: "sms", Levels: []string{"error"}, Throttle: 300}, func(event *frame.AlertEvent) error {
+//		return sendSms(event.Type)
+//	})
+// 处理函数的报错和panic输出到标准错误,不写日志,避免告警失败再次触发告警
+// SetErrorHandle 仍然可以使用,与告警互不影响
+
+//内置的webhook处理
+const AlertHandlerWebhook = "webhook"
+
+//默认告警队列长度
+const logAlertQueueSize = 1000
+
+//webhook默认重试间隔(毫秒)
+const alertRetryInterval = 500
+
+//告警规则
+type AlertRule struct {
+	Name          string   `toml:"name"`                           //规则名,用于统计和告警内容
+	Handler       string   `toml:"handler"`                        //处理函数,webhook 或 RegisterAlertHandler 注册的名字
+	Levels        []string `toml:"levels"`                         //级别,为空全部
+	Types         []string `toml:"types"`                          //日志类型,为空全部
+	Throttle      int      `toml:"throttle" validate:"min=0"`      //同一日志类型的告警间隔(秒),0不限制
+	Url           string   `toml:"url"`                            //webhook地址
+	ClientGroup   string   `toml:"clientGroup"`                    //webhook使用的http配置,默认 curl/client_default
+	Retry         int      `toml:"retry" validate:"min=0"`         //webhook失败后的重试次数
+	RetryInterval int      `toml:"retryInterval" validate:"min=0"` //webhook重试间隔(毫秒),默认500
+}
+
+//一次告警
+type AlertEvent struct {
+	Rule       string    `json:"rule"`       //规则名
+	App        string    `json:"app"`        //应用名
+	Host       string    `json:"host"`       //主机名
+	Level      string    `json:"level"`      //级别
+	Type       string    `json:"type"`       //日志类型
+	Time       time.Time `json:"time"`       //记录时间
+	Line       string    `json:"log"`        //格式化后的日志
+	Suppressed int       `json:"suppressed"` //上次告警后被节流的条数
+}
+
+//告警处理函数
+type AlertHandler func(event *AlertEvent) error
+
+//告警统计
+type AlertStats struct {
+	Sent      uint64 //处理成功
+	Failed    uint64 //处理失败
+	Dropped   uint64 //队列满或者已经关闭时丢弃
+	Throttled uint64 //节流的条数
+}
+
+var alertHandlers = make(map[string]AlertHandler)
+var alertHandlerLock sync.RWMutex
+
+//注册告警处理函数,配置中通过 handler 使用,需要在 App().Init 之前注册
+func RegisterAlertHandler(name string, handler AlertHandler) {
+	alertHandlerLock.Lock()
+	defer alertHandlerLock.Unlock()
+	alertHandlers[name] = handler
+}
+
+func getAlertHandler(name string) (AlertHandler, bool) {
+	alertHandlerLock.RLock()
+	defer alertHandlerLock.RUnlock()
+	handler, ok := alertHandlers[name]
+	return handler, ok
+}
+
+type logAlert struct {
+	sent      uint64 //原子操作,放在开头保证对齐
+	failed    uint64
+	dropped   uint64
+	throttled uint64
+	app       *app
+	host      string
+	lock      sync.RWMutex
+	routes    []*alertRoute
+	events    chan *alertTask
+	closed    bool
+	doneChan  chan bool
+}
+
+type alertRoute struct {
+	rule       *AlertRule
+	handler    AlertHandler
+	lock       sync.Mutex
+	last       map[string]time.Time //日志类型 => 上次告警时间
+	suppressed map[string]int       //日志类型 => 节流的条数
+}
+
+type alertTask struct {
+	route *alertRoute
+	event *AlertEvent
+}
+
+func newLogAlert(app *app, config *logConfig) (*logAlert, error) {
+	queueSize := config.AlertQueue
+	if queueSize <= 0 {
+		queueSize = logAlertQueueSize
+	}
+	alert := &logAlert{
+		app:      app,
+		host:     GetHostName(),
+		routes:   make([]*alertRoute, 0, len(config.Alerts)),
+		events:   make(chan *alertTask, queueSize),
+		doneChan: make(chan bool),
+	}
+	for _, rule := range config.Alerts {
+		if err := alert.add(rule, nil); err != nil {
+			return nil, err
+		}
+	}
+	return alert, nil
+}
+
+//添加规则,handler为空时按规则中的 Handler 查找
+func (alert *logAlert) add(rule *AlertRule, handler AlertHandler) error {
+	if handler == nil {
+		switch rule.Handler {
+		case AlertHandlerWebhook:
+			if rule.Url == "" {
+				return errors.New(LogAlertError.Error() + ":" + rule.Name + ":webhook 需要配置url")
+			}
+			handler = alert.webhook(rule)
+		default:
+			var ok bool
+			if handler, ok = getAlertHandler(rule.Handler); !ok {
+				return errors.New(LogAlertError.Error() + ":" + rule.Name + ":没有注册的处理函数 " + rule.Handler)
+			}
+		}
+	}
+	if rule.Name == "" {
+		rule.Name = rule.Handler
+	}
+	alert.lock.Lock()
+	defer alert.lock.Unlock()
+	alert.routes = append(alert.routes, &alertRoute{
+		rule:       rule,
+		handler:    handler,
+		last:       make(map[string]time.Time),
+		suppressed: make(map[string]int),
+	})
+	return nil
+}
+
+func (alert *logAlert) start() {
+	go func() {
+		defer close(alert.doneChan)
+		for task := range alert.events {
+			if err := alert.handle(task); err != nil {
+				atomic.AddUint64(&alert.failed, 1)
+				fmt.Fprintln(os.Stderr, "日志告警失败", task.route.rule.Name, err)
+				continue
+			}
+			atomic.AddUint64(&alert.sent, 1)
+		}
+	}()
+}
+
+//单次处理,报错和panic都作为失败
+func (alert *logAlert) handle(task *alertTask) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	return task.route.handler(task.event)
+}
+
+//匹配规则并放入队列
+func (alert *logAlert) dispatch(entry *LogEntry) {
+	alert.lock.RLock()
+	defer alert.lock.RUnlock()
+	for _, route := range alert.routes {
+		if !route.match(entry.Level, entry.Type) {
+			continue
+		}
+		suppressed, ok := route.throttle(entry.Type, entry.Time)
+		if !ok {
+			atomic.AddUint64(&alert.throttled, 1)
+			continue
+		}
+		if alert.closed {
+			atomic.AddUint64(&alert.dropped, 1)
+			continue
+		}
+		task := &alertTask{route: route, event: &AlertEvent{
+			Rule:       route.rule.Name,
+			App:        alert.app.AppName,
+			Host:       alert.host,
+			Level:      entry.Level,
+			Type:       entry.Type,
+			Time:       entry.Time,
+			Line:       strings.TrimSuffix(entry.Line, "\n"),
+			Suppressed: suppressed,
+		}}
+		select {
+		case alert.events <- task:
+		default:
+			atomic.AddUint64(&alert.dropped, 1)
+		}
+	}
+}
+
+func (route *alertRoute) match(level string, logType string) bool {
+	if len(route.rule.Levels) > 0 && !inStringSlice(level, route.rule.Levels) {
+		return false
+	}
+	if len(route.rule.Types) > 0 && !inStringSlice(logType, route.rule.Types) {
+		return false
+	}
+	return true
+}
+
+//是否可以告警,返回上次告警后被节流的条数
+func (route *alertRoute) throttle(logType string, now time.Time) (int, bool) {
+	if route.rule.Throttle <= 0 {
+		return 0, true
+	}
+	route.lock.Lock()
+	defer route.lock.Unlock()
+	if last, ok := route.last[logType]; ok && now.Sub(last) < time.Duration(route.rule.Throttle)*time.Second {
+		route.suppressed[logType]++
+		return 0, false
+	}
+	suppressed := route.suppressed[logType]
+	route.last[logType] = now
+	delete(route.suppressed, logType)
+	return suppressed, true
+}
+
+//处理完队列中的告警后关闭
+func (alert *logAlert) stop() {
+	alert.lock.Lock()
+	if alert.closed {
+		alert.lock.Unlock()
+		return
+	}
+	alert.closed = true
+	close(alert.events)
+	alert.lock.Unlock()
+	select {
+	case <-alert.doneChan:
+	case <-time.After(hookTimeout):
+	}
+}
+
+//内置webhook,以json POST告警内容,非200作为失败,失败后按配置重试
+func (alert *logAlert) webhook(rule *AlertRule) AlertHandler {
+	interval := rule.RetryInterval
+	if interval <= 0 {
+		interval = alertRetryInterval
+	}
+	return func(event *AlertEvent) error {
+		var err error
+		for i := 0; i <= rule.Retry; i++ {
+			if i > 0 {
+				time.Sleep(time.Duration(interval) * time.Millisecond)
+			}
+			curl := &Curl{App: alert.app, ClientGroup: rule.ClientGroup, quiet: true}
+			if _, err = curl.PostJson(rule.Url, event); err != nil {
+				continue
+			}
+			if curl.HttpCode() != http.StatusOK {
+				err = errors.New(HttpFailError.Error() + ":" + fmt.Sprintf("%d", curl.HttpCode()))
+				continue
+			}
+			return nil
+		}
+		return err
+	}
+}
+
+//添加告警规则,handler为空时使用规则中的 Handler
+func (myLog *log) AddAlert(rule *AlertRule, handler AlertHandler) error {
+	return myLog.alert.add(rule, handler)
+}
+
+//告警统计
+func (myLog *log) AlertStats() *AlertStats {
+	return &AlertStats{
+		Sent:      atomic.LoadUint64(&myLog.alert.sent),
+		Failed:    atomic.LoadUint64(&myLog.alert.failed),
+		Dropped:   atomic.LoadUint64(&myLog.alert.dropped),
+		Throttled: atomic.LoadUint64(&myLog.alert.throttled),
+	}
+}
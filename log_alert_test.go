@@ -0,0 +1,171 @@
+package frame
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+//本地http服务,记录收到的告警
+type alertTestServer struct {
+	lock     sync.Mutex
+	events   map[string][]*AlertEvent //路径 => 收到的告警
+	requests map[string]int           //路径 => 请求次数
+	fails    map[string]int           //路径 => 前几次返回500
+	types    map[string]string        //路径 => Content-Type
+}
+
+func (server *alertTestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	server.requests[r.URL.Path]++
+	server.types[r.URL.Path] = r.Header.Get("Content-Type")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if server.requests[r.URL.Path] <= server.fails[r.URL.Path] {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	event := &AlertEvent{}
+	if err := json.NewDecoder(r.Body).Decode(event); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	server.events[r.URL.Path] = append(server.events[r.URL.Path], event)
+}
+
+func (server *alertTestServer) received(path string) ([]*AlertEvent, int) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	return append([]*AlertEvent(nil), server.events[path]...), server.requests[path]
+}
+
+//等待收到count条告警
+func (server *alertTestServer) wait(t *testing.T, path string, count int) []*AlertEvent {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if events, _ := server.received(path); len(events) >= count {
+			return events
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	events, requests := server.received(path)
+	t.Fatalf("%s 收到 %d 条告警,%d 次请求,需要 %d 条", path, len(events), requests, count)
+	return nil
+}
+
+func newAlertTestApp(t *testing.T, url string) (*app, func()) {
+	dir, err := ioutil.TempDir("", "frame_alert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"develop/app.toml": `
+[log]
+path = "` + filepath.Join(dir, "logs") + `"
+[[log.alerts]]
+name = "retry"
+handler = "webhook"
+url = "` + url + `/retry"
+levels = ["error"]
+types = ["alert_retry"]
+retry = 2
+retryInterval = 10
+[[log.alerts]]
+name = "throttle"
+handler = "webhook"
+url = "` + url + `/throttle"
+types = ["alert_throttle"]
+throttle = 1
+`,
+		"develop/curl/client_default.toml": `
+timeout = 3
+`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	testApp := newApp().Init("develop", "alert_test", dir)
+	return testApp, func() {
+		_ = testApp.Shutdown()
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func TestLogAlertWebhook(t *testing.T) {
+	server := &alertTestServer{
+		events:   make(map[string][]*AlertEvent),
+		requests: make(map[string]int),
+		fails:    map[string]int{"/retry": 2},
+		types:    make(map[string]string),
+	}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	testApp, clean := newAlertTestApp(t, httpServer.URL)
+	defer clean()
+
+	t.Run("body and retry", func(t *testing.T) {
+		testApp.Log.Error(map[string]interface{}{"error": "connection refused"}, "alert_retry")
+		//级别不匹配的不告警
+		testApp.Log.Warn("warn", "alert_retry")
+		events := server.wait(t, "/retry", 1)
+		event := events[0]
+		if event.Rule != "retry" || event.Level != LogTypeError || event.Type != "alert_retry" || event.App != "alert_test" {
+			t.Fatalf("告警内容错误: %+v", event)
+		}
+		if event.Line == "" || event.Time.IsZero() {
+			t.Fatalf("告警缺少日志内容或时间: %+v", event)
+		}
+		server.lock.Lock()
+		contentType := server.types["/retry"]
+		server.lock.Unlock()
+		if contentType != "application/json" {
+			t.Fatalf("Content-Type 错误: %s", contentType)
+		}
+		//前两次500,重试两次后成功
+		if _, requests := server.received("/retry"); requests != 3 {
+			t.Fatalf("请求次数 %d,需要 3", requests)
+		}
+		time.Sleep(50 * time.Millisecond)
+		if events, _ := server.received("/retry"); len(events) != 1 {
+			t.Fatalf("收到 %d 条告警,需要 1", len(events))
+		}
+	})
+
+	t.Run("throttle", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			testApp.Log.Error("throttle", "alert_throttle")
+		}
+		server.wait(t, "/throttle", 1)
+		time.Sleep(50 * time.Millisecond)
+		if events, _ := server.received("/throttle"); len(events) != 1 {
+			t.Fatalf("节流时间内收到 %d 条告警,需要 1", len(events))
+		}
+		//节流时间过后再次告警,带上被节流的条数
+		time.Sleep(1100 * time.Millisecond)
+		testApp.Log.Error("throttle", "alert_throttle")
+		events := server.wait(t, "/throttle", 2)
+		if events[1].Suppressed != 2 {
+			t.Fatalf("被节流 %d 条,需要 2", events[1].Suppressed)
+		}
+	})
+
+	stats := testApp.Log.AlertStats()
+	if stats.Sent != 3 || stats.Failed != 0 || stats.Throttled != 2 {
+		t.Fatalf("统计错误: %+v", stats)
+	}
+}
@@ -0,0 +1,236 @@
+package frame
+
+import (
+	"github.com/gin-gonic/gin"
+	"time"
+)
+
+// 行为日志
+// 配置了 behaviorPath 后行为日志写入单独的目录,有自己的切分和清理,不再和错误日志混在一起,也不进入其他输出和告警
+//	[log]
+//	behaviorPath = "/data/behavior/api"
+//	[log.behavior]
+//	rotate = "hour"     #hour|day,按小时或者按天切分文件,默认hour
+//	keepDays = 30
+//	maxSize = 10240     #MB
+//	compress = true
+// 没有配置 behaviorPath 时和原来一样写入 path 下的 behavior 目录
+// 事件格式固定为: {"event":"login","user_id":"1","properties":{},"request_id":"","ts":1673377445123}
+// 原来的 Log.Behavior(msg, name) 也写成同样的格式,name 为事件名,msg 为属性
+//	frame.LogFromContext(c).Track("login", "1", map[string]interface{}{"channel": "wechat"})
+// 每个请求记录一个事件的中间件:
+//	frame.BehaviorMiddleware(&frame.BehaviorOptions{
+//		All: false,                                  //true时没有配置的路由也记录,事件名为 request
+//		Routes: map[string]*frame.BehaviorRoute{
+//			"/user/login": {Event: "login"},
+//			"/user/info":  {Skip: true},
+//			"/order/pay":  {Event: "pay", Properties: func(c *gin.Context) map[string]interface{} {
+//				return map[string]interface{}{"order_id": c.PostForm("order_id")}
+//			}},
+//		},
+//	})
+// 路由为gin的完整路由(c.FullPath()),用户id默认取 c.GetString("userId")
+// NewApp 创建的应用需要设置 App,否则写入默认应用的行为日志
+// 业务中可以通过 frame.BehaviorProperty(c, "key", value) 给当前请求的事件增加属性
+
+//没有配置事件名时的事件名
+const BehaviorEventRequest = "request"
+
+//gin.Context 中用户id的key
+const BehaviorUserIdKey = "userId"
+
+//gin.Context 中请求附加属性的key
+const behaviorPropertiesKey = "frameBehaviorProperties"
+
+//按小时和按天切分的路径模板
+const behaviorTemplateHour = "{date}/{hour}.log"
+const behaviorTemplateDay = "{date}.log"
+
+//[log.behavior] 配置
+type logBehaviorConfig struct {
+	Rotate   string `toml:"rotate" validate:"oneof=hour day"` //按小时或者按天切分文件,默认hour
+	KeepDays int    `toml:"keepDays" validate:"min=0"`        //保留天数,0不删除
+	MaxSize  int    `toml:"maxSize" validate:"min=0"`         //最大容量(MB),0不限制
+	Compress bool   `toml:"compress"`                         //是否压缩已经结束的文件
+}
+
+//行为事件
+type BehaviorEvent struct {
+	Event      string                 `json:"event"`      //事件名
+	UserId     string                 `json:"user_id"`    //用户id
+	Properties map[string]interface{} `json:"properties"` //属性
+	RequestId  string                 `json:"request_id"` //请求id
+	Ts         int64                  `json:"ts"`         //时间戳(毫秒)
+}
+
+//单个路由的配置
+type BehaviorRoute struct {
+	Event      string                                      //事件名,为空时为 request
+	Skip       bool                                        //不记录
+	UserId     func(c *gin.Context) string                 //用户id,为空时取 c.GetString("userId")
+	Properties func(c *gin.Context) map[string]interface{} //附加属性
+}
+
+//中间件配置
+type BehaviorOptions struct {
+	All    bool                      //没有配置的路由也记录
+	Routes map[string]*BehaviorRoute //路由 => 配置
+	App    *app                      //所属应用,为空使用默认应用
+}
+
+//单独的行为日志,写入 behaviorPath
+func (app *app) newBehaviorLog(config *logConfig, layout *logLayout) *log {
+	behaviorConfig := config.Behavior
+	if behaviorConfig == nil {
+		behaviorConfig = &logBehaviorConfig{}
+	}
+	coreConfig := &logConfig{
+		Path:            config.BehaviorPath,
+		KeepDays:        behaviorConfig.KeepDays,
+		MaxSize:         behaviorConfig.MaxSize,
+		Compress:        behaviorConfig.Compress,
+		JanitorInterval: config.JanitorInterval,
+		SyncWrite:       config.SyncWrite,
+		BufferSize:      config.BufferSize,
+		FlushInterval:   config.FlushInterval,
+	}
+	behaviorLog := &log{logCore: &logCore{path: config.BehaviorPath, config: coreConfig}}
+	behaviorLog.layout = &logLayout{
+		root:       config.BehaviorPath,
+		template:   behaviorTemplateHour,
+		app:        layout.app,
+		host:       layout.host,
+		format:     LogFormatJson,
+		timeLayout: layout.timeLayout,
+		location:   layout.location,
+	}
+	if behaviorConfig.Rotate == "day" {
+		behaviorLog.layout.template = behaviorTemplateDay
+	}
+	behaviorLog.sinks = []*logSinkRoute{{name: LogTypeBehavior, sink: &fileSink{log: behaviorLog}, stats: &LogSinkStats{}}}
+	if !coreConfig.SyncWrite {
+		behaviorLog.writer = newLogWriter(behaviorLog)
+		behaviorLog.writer.start()
+		app.OnShutdown("behavior_log_writer", HookPriorityResource+200, func() error {
+			behaviorLog.writer.stop()
+			return nil
+		})
+	}
+	if coreConfig.KeepDays > 0 || coreConfig.MaxSize > 0 || coreConfig.Compress {
+		behaviorLog.janitor = newLogJanitor(behaviorLog)
+		behaviorLog.janitor.start()
+		app.OnShutdown("behavior_log_janitor", HookPriorityResource+100, func() error {
+			behaviorLog.janitor.stop()
+			return nil
+		})
+	}
+	return behaviorLog
+}
+
+//写入行为日志,配置了 behaviorPath 时写入单独的目录
+func (myLog *log) writeBehavior(entry *LogEntry) {
+	if myLog.behavior != nil {
+		myLog.behavior.writeSinks(entry)
+		return
+	}
+	myLog.writeSinks(entry)
+}
+
+//记录行为事件,请求id取日志附加字段中的 request_id
+func (myLog *log) Track(event string, userId string, properties map[string]interface{}) {
+	requestId, _ := myLog.fields["request_id"].(string)
+	myLog.TrackEvent(&BehaviorEvent{
+		Event:      event,
+		UserId:     userId,
+		Properties: properties,
+		RequestId:  requestId,
+	})
+}
+
+//记录行为事件,Ts为空时使用当前时间
+func (myLog *log) TrackEvent(event *BehaviorEvent) {
+	now := myLog.layout.now()
+	if event.Ts == 0 {
+		event.Ts = now.UnixNano() / int64(time.Millisecond)
+	}
+	if event.Properties == nil {
+		event.Properties = make(map[string]interface{})
+	}
+	line, err := jsonMarshal(event)
+	if err != nil {
+		myLog.errorStack(map[string]interface{}{
+			"event": event.Event,
+			"error": err.Error(),
+		}, LogBehaviorError)
+		return
+	}
+	myLog.writeBehavior(&LogEntry{Level: LogTypeBehavior, Type: event.Event, Time: now, Line: string(line)})
+}
+
+//Behavior 的msg转成属性
+func behaviorProperties(msg interface{}) map[string]interface{} {
+	switch v := msg.(type) {
+	case map[string]interface{}:
+		return v
+	case error:
+		return map[string]interface{}{"msg": v.Error()}
+	case nil:
+		return make(map[string]interface{})
+	}
+	return map[string]interface{}{"msg": msg}
+}
+
+//给当前请求的行为事件增加属性
+func BehaviorProperty(c *gin.Context, key string, value interface{}) {
+	properties, ok := c.Get(behaviorPropertiesKey)
+	if !ok {
+		properties = make(map[string]interface{})
+		c.Set(behaviorPropertiesKey, properties)
+	}
+	properties.(map[string]interface{})[key] = value
+}
+
+//每个请求记录一个行为事件
+func BehaviorMiddleware(options *BehaviorOptions) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route, ok := options.Routes[c.FullPath()]
+		if !ok {
+			if !options.All {
+				return
+			}
+			route = &BehaviorRoute{}
+		}
+		if route.Skip {
+			return
+		}
+		event := route.Event
+		if event == "" {
+			event = BehaviorEventRequest
+		}
+		userId := c.GetString(BehaviorUserIdKey)
+		if route.UserId != nil {
+			userId = route.UserId(c)
+		}
+		properties := map[string]interface{}{
+			"method":     c.Request.Method,
+			"route":      c.FullPath(),
+			"path":       c.Request.URL.Path,
+			"status":     c.Writer.Status(),
+			"latency_ms": time.Since(start).Milliseconds(),
+			"ip":         c.ClientIP(),
+		}
+		if route.Properties != nil {
+			for k, v := range route.Properties(c) {
+				properties[k] = v
+			}
+		}
+		if value, ok := c.Get(behaviorPropertiesKey); ok {
+			for k, v := range value.(map[string]interface{}) {
+				properties[k] = v
+			}
+		}
+		getApp(options.App).LogFromContext(c).Track(event, userId, properties)
+	}
+}
@@ -0,0 +1,86 @@
+package frame
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+)
+
+// 带上下文字段的日志
+// 用法:
+//	frame.App().Log.With(map[string]interface{}{"order_id": 1}).Error("支付失败", "pay_error")
+//	frame.LogFromContext(c).Error(err, "user_error") //c 为 *gin.Context,自动带上 request_id route ip app host
+// 字段写在日志的 fields 中
+// Mysql Redis Curl 以及缓存和计数器通过 WithContext(c) 传入上下文后,报错日志会自动带上这些字段
+// 非gin的上下文可以通过 ContextWithLog 放入日志
+
+//日志在上下文中的key,使用私有类型避免和其他包冲突
+type logContextKeyType struct{}
+
+var logContextKey = logContextKeyType{}
+
+//生成带附加字段的子日志,与原日志共用输出和配置
+func (myLog *log) With(fields map[string]interface{}) *log {
+	merged := make(map[string]interface{}, len(myLog.fields)+len(fields))
+	for k, v := range myLog.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &log{logCore: myLog.logCore, fields: merged}
+}
+
+//附加的字段
+func (myLog *log) Fields() map[string]interface{} {
+	result := make(map[string]interface{}, len(myLog.fields))
+	for k, v := range myLog.fields {
+		result[k] = v
+	}
+	return result
+}
+
+//默认应用的上下文日志
+func LogFromContext(ctx context.Context) *log {
+	return App().LogFromContext(ctx)
+}
+
+//上下文中的日志
+// ctx 为 *gin.Context 时生成带请求信息的子日志并缓存到请求的上下文中
+// 其他上下文读取 ContextWithLog 放入的日志,都没有时返回应用日志
+func (app *app) LogFromContext(ctx context.Context) *log {
+	if ctx == nil {
+		return app.Log
+	}
+	if c, ok := ctx.(*gin.Context); ok {
+		if c.Request != nil {
+			if logger, ok := c.Request.Context().Value(logContextKey).(*log); ok {
+				return logger
+			}
+		}
+		logger := app.Log.With(map[string]interface{}{
+			"request_id": c.GetString("requestId"),
+			"route":      c.FullPath(),
+			"ip":         c.ClientIP(),
+			"app":        app.AppName,
+			"host":       app.Log.layout.host,
+		})
+		if c.Request != nil {
+			c.Request = c.Request.WithContext(ContextWithLog(c.Request.Context(), logger))
+		}
+		return logger
+	}
+	if logger, ok := ctx.Value(logContextKey).(*log); ok {
+		return logger
+	}
+	return app.Log
+}
+
+//把日志放入上下文,用于在协程等非gin的上下文中传递
+func ContextWithLog(ctx context.Context, logger *log) context.Context {
+	return context.WithValue(ctx, logContextKey, logger)
+}
+
+//组件报错时使用的日志,有上下文时使用上下文日志
+func contextLog(app *app, ctx context.Context) *log {
+	return getApp(app).LogFromContext(ctx)
+}
@@ -0,0 +1,194 @@
+package frame
+
+import (
+	"sync"
+	"time"
+)
+
+// 重复日志抑制
+// 相同 日志类型+错误信息 的日志在一个窗口内最多记录 limit 条,超过的丢弃并计数
+// 窗口结束后写一条汇总日志,记录被抑制的条数,SetErrorHandle 的处理函数同样只会被调用 limit+1 次
+// app.toml 中配置:
+//	[log.dedup]
+//	window = 60          #窗口(秒),0或不配置时关闭
+//	limit = 10           #窗口内相同日志最多记录的条数,默认10
+//	[log.dedup.types]
+//	redis_error = 3      #按日志类型单独设置,0为不限制
+//	mysql_slow = 0
+// 错误信息取 msg 中的 error 字段,没有时取去掉 stack 后的整个 msg
+// 行为日志不做抑制
+
+//默认窗口内相同日志最多记录的条数
+const logDedupLimit = 10
+
+//最多跟踪的不同日志数,超过后新的日志不再抑制
+const logDedupMaxKeys = 10000
+
+type logDedupConfig struct {
+	Window int            `toml:"window" validate:"min=0"` //窗口(秒),0关闭
+	Limit  int            `toml:"limit" validate:"min=0"`  //窗口内相同日志最多记录的条数,默认10
+	Types  map[string]int `toml:"types"`                   //日志类型 => 条数,0不限制
+}
+
+type logDedup struct {
+	log      *log
+	window   time.Duration
+	limit    int
+	types    map[string]int
+	lock     sync.Mutex
+	entries  map[string]*logDedupEntry
+	stopChan chan bool
+	doneChan chan bool
+}
+
+type logDedupEntry struct {
+	level      string
+	logType    string
+	message    string
+	start      time.Time
+	count      int
+	suppressed int
+}
+
+func newLogDedup(log *log, config *logDedupConfig) *logDedup {
+	limit := config.Limit
+	if limit <= 0 {
+		limit = logDedupLimit
+	}
+	types := make(map[string]int)
+	for k, v := range config.Types {
+		types[k] = v
+	}
+	return &logDedup{
+		log:     log,
+		window:  time.Duration(config.Window) * time.Second,
+		limit:   limit,
+		types:   types,
+		entries: make(map[string]*logDedupEntry),
+	}
+}
+
+//定时写入已经结束的窗口的汇总
+func (dedup *logDedup) start() {
+	dedup.stopChan = make(chan bool)
+	dedup.doneChan = make(chan bool)
+	go func() {
+		defer close(dedup.doneChan)
+		ticker := time.NewTicker(dedup.window)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				dedup.sweep(false)
+			case <-dedup.stopChan:
+				dedup.sweep(true)
+				return
+			}
+		}
+	}()
+}
+
+//停止并写入所有的汇总
+func (dedup *logDedup) stop() {
+	if dedup.stopChan == nil {
+		return
+	}
+	close(dedup.stopChan)
+	<-dedup.doneChan
+	dedup.stopChan = nil
+}
+
+//是否记录,超过条数时返回false
+func (dedup *logDedup) allow(tpl *logTpl, now time.Time) bool {
+	limit := dedup.limit
+	if typeLimit, ok := dedup.types[tpl.Type]; ok {
+		limit = typeLimit
+	}
+	if limit <= 0 {
+		return true
+	}
+	message := logDedupMessage(tpl.Msg)
+	key := tpl.Type + "\x00" + message
+	var summary *logDedupEntry
+	dedup.lock.Lock()
+	entry, ok := dedup.entries[key]
+	if ok && now.Sub(entry.start) >= dedup.window {
+		delete(dedup.entries, key)
+		if entry.suppressed > 0 {
+			summary = entry
+		}
+		ok = false
+	}
+	if !ok {
+		if len(dedup.entries) >= logDedupMaxKeys {
+			dedup.lock.Unlock()
+			return true
+		}
+		entry = &logDedupEntry{level: tpl.Level, logType: tpl.Type, message: message, start: now}
+		dedup.entries[key] = entry
+	}
+	entry.count++
+	allowed := entry.count <= limit
+	if !allowed {
+		entry.suppressed++
+	}
+	dedup.lock.Unlock()
+	if summary != nil {
+		dedup.writeSummary(summary, now)
+	}
+	return allowed
+}
+
+//清理已经结束的窗口,all为true时清理全部
+func (dedup *logDedup) sweep(all bool) {
+	now := dedup.log.layout.now()
+	summaries := make([]*logDedupEntry, 0)
+	dedup.lock.Lock()
+	for key, entry := range dedup.entries {
+		if !all && now.Sub(entry.start) < dedup.window {
+			continue
+		}
+		delete(dedup.entries, key)
+		if entry.suppressed > 0 {
+			summaries = append(summaries, entry)
+		}
+	}
+	dedup.lock.Unlock()
+	for _, entry := range summaries {
+		dedup.writeSummary(entry, now)
+	}
+}
+
+//汇总日志,使用原日志的级别和类型
+func (dedup *logDedup) writeSummary(entry *logDedupEntry, now time.Time) {
+	dedup.log.output(&logTpl{
+		Level: entry.level,
+		Type:  entry.logType,
+		Msg: map[string]interface{}{
+			"summary":    "重复日志已抑制",
+			"error":      entry.message,
+			"suppressed": entry.suppressed,
+			"logged":     entry.count - entry.suppressed,
+			"since":      dedup.log.layout.formatTime(entry.start),
+		},
+	}, now)
+}
+
+//用于判断重复的错误信息
+func logDedupMessage(msg interface{}) string {
+	if msgMap, ok := msg.(map[string]interface{}); ok {
+		if err, ok := msgMap["error"]; ok {
+			return logValueString(err)
+		}
+		if _, ok := msgMap["stack"]; ok {
+			withoutStack := make(map[string]interface{}, len(msgMap))
+			for k, v := range msgMap {
+				if k != "stack" {
+					withoutStack[k] = v
+				}
+			}
+			return logValueString(withoutStack)
+		}
+	}
+	return logValueString(msg)
+}
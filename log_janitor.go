@@ -0,0 +1,268 @@
+package frame
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// 日志清理
+// app.toml 中配置:
+//	[log]
+//	path = "/data/logs/api"
+//	keepDays = 7          #保留7天,按文件修改时间计算
+//	maxSize = 1024        #每个级别目录最多1024MB,超过从最旧的文件开始删除(路径模板不以{level}开头时按全部文件计算)
+//	compress = true       #已经结束的小时日志压缩成 .log.gz
+//	janitorInterval = 600 #每10分钟清理一次
+// 多个进程共用日志目录时,通过目录下的 .janitor.lock 文件锁保证同一时间只有一个进程在清理
+// 正在写入的当前小时文件不会被删除和压缩
+
+//默认清理间隔(秒)
+const logJanitorInterval = 600
+
+//小时结束后多久压缩,等待其他进程写完
+const logCompressDelay = time.Minute
+
+//空目录最近修改后多久才删除,新建的目录可能马上就会写入文件
+const logEmptyDirAge = time.Hour
+
+const logJanitorLockFile = ".janitor.lock"
+
+type logJanitor struct {
+	log      *log
+	stopChan chan bool
+	doneChan chan bool
+}
+
+type logFileInfo struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+func newLogJanitor(log *log) *logJanitor {
+	return &logJanitor{log: log}
+}
+
+func (janitor *logJanitor) start() {
+	seconds := janitor.log.config.JanitorInterval
+	if seconds <= 0 {
+		seconds = logJanitorInterval
+	}
+	janitor.stopChan = make(chan bool)
+	janitor.doneChan = make(chan bool)
+	go func() {
+		defer close(janitor.doneChan)
+		janitor.clean()
+		ticker := time.NewTicker(time.Duration(seconds) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				janitor.clean()
+			case <-janitor.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+//停止清理,等待正在进行的清理结束
+func (janitor *logJanitor) stop() {
+	if janitor.stopChan == nil {
+		return
+	}
+	close(janitor.stopChan)
+	<-janitor.doneChan
+	janitor.stopChan = nil
+}
+
+//执行一次清理,其他进程正在清理时跳过
+func (janitor *logJanitor) clean() {
+	defer func() {
+		if err := recover(); err != nil {
+			fmt.Println("日志清理失败", err)
+		}
+	}()
+	lockFile, ok := janitor.lock()
+	if !ok {
+		return
+	}
+	defer janitor.unlock(lockFile)
+	root := janitor.log.layout.root
+	now := time.Now()
+	current := janitor.log.layout.currentFiles(now)
+	groups := make(map[string][]*logFileInfo)
+	for _, file := range listLogFiles(root) {
+		rel, err := filepath.Rel(root, file.path)
+		if err != nil {
+			continue
+		}
+		group := ""
+		if strings.HasPrefix(janitor.log.layout.template, "{level}") {
+			group = strings.SplitN(filepath.ToSlash(rel), "/", 2)[0]
+		}
+		groups[group] = append(groups[group], file)
+	}
+	for _, files := range groups {
+		janitor.cleanFiles(files, current, now)
+	}
+	removeEmptyDirs(root, current, now)
+}
+
+func (janitor *logJanitor) lock() (*os.File, bool) {
+	lockFile, err := os.OpenFile(filepath.Join(janitor.log.layout.root, logJanitorLockFile), os.O_CREATE|os.O_RDWR, 0666)
+	if err != nil {
+		return nil, false
+	}
+	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		_ = lockFile.Close()
+		return nil, false
+	}
+	return lockFile, true
+}
+
+func (janitor *logJanitor) unlock(lockFile *os.File) {
+	_ = syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
+	_ = lockFile.Close()
+}
+
+//清理单个级别目录的文件,依次 过期删除 -> 压缩 -> 容量限制
+func (janitor *logJanitor) cleanFiles(files []*logFileInfo, current map[string]bool, now time.Time) {
+	config := janitor.log.config
+	if config.KeepDays > 0 {
+		expire := now.AddDate(0, 0, -config.KeepDays)
+		remain := make([]*logFileInfo, 0, len(files))
+		for _, file := range files {
+			if !current[file.path] && file.modTime.Before(expire) {
+				_ = os.Remove(file.path)
+				continue
+			}
+			remain = append(remain, file)
+		}
+		files = remain
+	}
+	if config.Compress {
+		for _, file := range files {
+			if current[file.path] || !strings.HasSuffix(file.path, ".log") {
+				continue
+			}
+			//刚写入过的可能还有进程在写
+			if now.Sub(file.modTime) < logCompressDelay {
+				continue
+			}
+			if gzFile, err := compressLogFile(file); err == nil {
+				*file = *gzFile
+			}
+		}
+	}
+	if config.MaxSize > 0 {
+		var total int64
+		for _, file := range files {
+			total += file.size
+		}
+		maxSize := int64(config.MaxSize) * 1024 * 1024
+		sort.Slice(files, func(i, j int) bool {
+			return files[i].modTime.Before(files[j].modTime)
+		})
+		for _, file := range files {
+			if total <= maxSize {
+				break
+			}
+			if current[file.path] {
+				continue
+			}
+			if os.Remove(file.path) == nil {
+				total -= file.size
+			}
+		}
+	}
+}
+
+//目录下所有日志文件
+func listLogFiles(levelPath string) []*logFileInfo {
+	files := make([]*logFileInfo, 0)
+	_ = filepath.Walk(levelPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		if !strings.HasSuffix(path, ".log") && !strings.HasSuffix(path, ".log.gz") {
+			return nil
+		}
+		files = append(files, &logFileInfo{path: path, size: info.Size(), modTime: info.ModTime()})
+		return nil
+	})
+	return files
+}
+
+//压缩成 .log.gz,先写临时文件再改名,保留原文件修改时间
+func compressLogFile(file *logFileInfo) (*logFileInfo, error) {
+	src, err := os.Open(file.path)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	gzPath := file.path + ".gz"
+	tmpPath := gzPath + ".tmp"
+	dst, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+	if err != nil {
+		return nil, err
+	}
+	writer := gzip.NewWriter(dst)
+	_, err = io.Copy(writer, src)
+	if err == nil {
+		err = writer.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, gzPath)
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return nil, err
+	}
+	_ = os.Chtimes(gzPath, file.modTime, file.modTime)
+	_ = os.Remove(file.path)
+	info, err := os.Stat(gzPath)
+	if err != nil {
+		return nil, err
+	}
+	return &logFileInfo{path: gzPath, size: info.Size(), modTime: file.modTime}, nil
+}
+
+//删除空目录,正在写入的文件所在的目录保留
+//一小时内修改过的目录也保留,避免删除其他进程刚创建、还没有写入文件的新目录
+func removeEmptyDirs(dirPath string, current map[string]bool, now time.Time) bool {
+	files, err := ioutil.ReadDir(dirPath)
+	if err != nil {
+		return false
+	}
+	empty := true
+	for _, file := range files {
+		if !file.IsDir() || !removeEmptyDirs(filepath.Join(dirPath, file.Name()), current, now) {
+			empty = false
+		}
+	}
+	if !empty {
+		return false
+	}
+	for currentFile := range current {
+		if strings.HasPrefix(currentFile, dirPath+string(filepath.Separator)) {
+			return false
+		}
+	}
+	info, err := os.Stat(dirPath)
+	if err != nil || now.Sub(info.ModTime()) < logEmptyDirAge {
+		return false
+	}
+	return os.Remove(dirPath) == nil
+}
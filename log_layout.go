@@ -0,0 +1,207 @@
+package frame
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 日志文件路径和内容格式
+// app.toml 中配置:
+//	[log]
+//	pathTemplate = "{level}/{date}/{hour}.log" #相对于path的文件路径,默认的目录结构和文件名与原来一致,日期补齐两位
+//	prefix = "app/host"                        #在path下增加 应用名/主机名 目录,可选 app|host|app/host,默认不加
+//	format = "json"                            #json|logfmt|text,默认json
+//	timeFormat = "rfc3339"                     #rfc3339为带毫秒和时区的RFC3339,也可以写go的时间格式,默认 2006-01-02 15:04:05(原来的格式补齐两位)
+//	timezone = "Asia/Shanghai"                 #时间和目录使用的时区,默认本地时区
+// 路径模板支持的变量:
+//	{level} 级别 {app} 应用名 {host} 主机名
+//	{Y} 年 {m} 月 {d} 日 {H} 时,月日时都补齐两位
+//	{date} 等于 {Y}{m}{d}  {hour} 不补零的小时,和原来的文件名一致,如 3.log
+// 路径模板必须包含 {level} 并以 .log 结尾
+// 行为日志固定使用json格式
+
+//默认路径模板
+const LogPathTemplate = "{level}/{date}/{hour}.log"
+
+//日志内容格式
+const LogFormatJson = "json"
+const LogFormatLogfmt = "logfmt"
+const LogFormatText = "text"
+
+//带毫秒和时区的RFC3339时间格式
+const LogTimeRFC3339 = "2006-01-02T15:04:05.000Z07:00"
+
+//默认时间格式,和原来的格式一致,月日时分秒补齐两位
+const logTimeDefault = "2006-01-02 15:04:05"
+
+type logLayout struct {
+	root       string //日志根目录,path加上prefix目录
+	template   string
+	app        string
+	host       string
+	format     string
+	timeLayout string
+	location   *time.Location
+}
+
+func newLogLayout(config *logConfig, appName string) (*logLayout, error) {
+	layout := &logLayout{
+		root:       config.Path,
+		template:   config.PathTemplate,
+		app:        appName,
+		host:       GetHostName(),
+		format:     config.Format,
+		timeLayout: config.TimeFormat,
+		location:   time.Local,
+	}
+	if layout.template == "" {
+		layout.template = LogPathTemplate
+	}
+	if !strings.Contains(layout.template, "{level}") || !strings.HasSuffix(layout.template, ".log") {
+		return nil, errors.New(LogLayoutError.Error() + ":pathTemplate 必须包含{level}并以.log结尾")
+	}
+	switch config.Prefix {
+	case "":
+	case "app":
+		layout.root += "/" + layout.app
+	case "host":
+		layout.root += "/" + layout.host
+	case "app/host":
+		layout.root += "/" + layout.app + "/" + layout.host
+	default:
+		return nil, errors.New(LogLayoutError.Error() + ":prefix 只能是app|host|app/host")
+	}
+	switch layout.format {
+	case "":
+		layout.format = LogFormatJson
+	case LogFormatJson, LogFormatLogfmt, LogFormatText:
+	default:
+		return nil, errors.New(LogLayoutError.Error() + ":format 只能是json|logfmt|text")
+	}
+	switch strings.ToLower(layout.timeLayout) {
+	case "":
+		layout.timeLayout = logTimeDefault
+	case "rfc3339":
+		layout.timeLayout = LogTimeRFC3339
+	}
+	if config.Timezone != "" {
+		location, err := time.LoadLocation(config.Timezone)
+		if err != nil {
+			return nil, errors.New(LogLayoutError.Error() + ":timezone " + err.Error())
+		}
+		layout.location = location
+	}
+	return layout, nil
+}
+
+//当前时间,使用配置的时区
+func (layout *logLayout) now() time.Time {
+	return time.Now().In(layout.location)
+}
+
+//所在小时的开始,按配置的时区计算,时区偏移不是整小时时 Truncate 会错开
+func (layout *logLayout) hourStart(t time.Time) time.Time {
+	t = t.In(layout.location)
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, layout.location)
+}
+
+func (layout *logLayout) formatTime(now time.Time) string {
+	return now.In(layout.location).Format(layout.timeLayout)
+}
+
+//级别在某个时间写入的文件
+func (layout *logLayout) filePath(level string, now time.Time) string {
+	now = now.In(layout.location)
+	replacer := strings.NewReplacer(
+		"{level}", level,
+		"{app}", layout.app,
+		"{host}", layout.host,
+		"{date}", now.Format("20060102"),
+		"{hour}", strconv.Itoa(now.Hour()),
+		"{Y}", now.Format("2006"),
+		"{m}", now.Format("01"),
+		"{d}", now.Format("02"),
+		"{H}", now.Format("15"),
+	)
+	return filepath.Join(layout.root, replacer.Replace(layout.template))
+}
+
+//所有级别当前正在写入的文件
+func (layout *logLayout) currentFiles(now time.Time) map[string]bool {
+	files := make(map[string]bool)
+	for _, level := range []string{LogTypeDebug, LogTypeInfo, LogTypeWarn, LogTypeError, LogTypeBehavior} {
+		files[layout.filePath(level, now)] = true
+	}
+	return files
+}
+
+//按配置的格式生成一行日志
+func (layout *logLayout) formatLine(tpl *logTpl) (string, error) {
+	switch layout.format {
+	case LogFormatLogfmt:
+		pairs := []string{
+			"time=" + logfmtValue(tpl.Time),
+			"level=" + logfmtValue(tpl.Level),
+			"type=" + logfmtValue(tpl.Type),
+			"pid=" + strconv.Itoa(tpl.Pid),
+			"msg=" + logfmtValue(tpl.Msg),
+		}
+		for _, key := range sortedFieldKeys(tpl.Fields) {
+			pairs = append(pairs, key+"="+logfmtValue(tpl.Fields[key]))
+		}
+		return strings.Join(pairs, " ") + "\n", nil
+	case LogFormatText:
+		line := tpl.Time + " [" + tpl.Level + "] " + tpl.Type + " pid=" + strconv.Itoa(tpl.Pid) + " " + logTextValue(tpl.Msg)
+		for _, key := range sortedFieldKeys(tpl.Fields) {
+			line += " " + key + "=" + logfmtValue(tpl.Fields[key])
+		}
+		return line + "\n", nil
+	}
+	msg, err := jsonMarshal(tpl)
+	return string(msg), err
+}
+
+func sortedFieldKeys(fields map[string]interface{}) []string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+//字符串原样输出,其他类型转成json
+func logValueString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	msg, err := jsonMarshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return strings.TrimSuffix(string(msg), "\n")
+}
+
+//text格式的值,换行转义保证一条日志一行
+func logTextValue(value interface{}) string {
+	return strings.NewReplacer("\r", "\\r", "\n", "\\n").Replace(logValueString(value))
+}
+
+//logfmt的值,包含空格 等号 引号 换行时加引号
+func logfmtValue(value interface{}) string {
+	str := logValueString(value)
+	if str == "" || strings.ContainsAny(str, " =\"\t\r\n") {
+		return strconv.Quote(str)
+	}
+	return str
+}
@@ -0,0 +1,268 @@
+package frame
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// 日志级别过滤
+// app.toml 中配置,不同环境使用各自的配置:
+//	[log]
+//	level = "info"            #最低级别 debug|info|warn|error|off,默认debug全部记录
+//	[log.types]
+//	mysql_slow = "off"        #按日志类型单独设置,优先于level
+//	curl_error = "debug"
+// 行为日志不受级别限制
+// 运行时修改:
+//	frame.App().Log.SetLevel("warn")
+//	frame.App().Log.SetTypeLevel("curl_error", "debug")
+//	route.Any("/admin/log/level", frame.LogLevelHandler()) //GET查看,POST level=warn&type=curl_error 修改
+//	kill -USR2 <pid>                                       //重新读取app.toml中的级别配置
+//	frame.App().Log.ResetLevels()                          //清除运行时的设置,恢复app.toml中的级别
+// app.toml 变化时(开启了配置监听)也会重新读取
+// 运行时的设置优先于app.toml,重新读取配置后仍然保留,直到 ResetLevels
+// 信号整个进程只监听一次,收到后所有未关闭的应用(包括 NewApp 创建的)都重新读取
+
+//关闭日志
+const LogLevelOff = "off"
+
+//重新读取日志级别的信号
+const LogLevelSignal = syscall.SIGUSR2
+
+//级别从低到高
+var logLevels = []string{LogTypeDebug, LogTypeInfo, LogTypeWarn, LogTypeError, LogLevelOff}
+
+type logLevelConfig struct {
+	level int            //最低级别
+	types map[string]int //日志类型 => 最低级别
+}
+
+func logLevelIndex(level string) (int, error) {
+	for k, v := range logLevels {
+		if v == strings.ToLower(level) {
+			return k, nil
+		}
+	}
+	return 0, errors.New(LogLevelError.Error() + ":" + level)
+}
+
+func newLogLevelConfig(level string, types map[string]string) (*logLevelConfig, error) {
+	levelConfig := &logLevelConfig{types: make(map[string]int)}
+	if level != "" {
+		index, err := logLevelIndex(level)
+		if err != nil {
+			return nil, err
+		}
+		levelConfig.level = index
+	}
+	for logType, typeLevel := range types {
+		index, err := logLevelIndex(typeLevel)
+		if err != nil {
+			return nil, errors.New(err.Error() + "(" + logType + ")")
+		}
+		levelConfig.types[logType] = index
+	}
+	return levelConfig, nil
+}
+
+func (levelConfig *logLevelConfig) copy() *logLevelConfig {
+	result := &logLevelConfig{level: levelConfig.level, types: make(map[string]int)}
+	for k, v := range levelConfig.types {
+		result.types[k] = v
+	}
+	return result
+}
+
+//是否需要记录
+func (myLog *log) enabled(level string, logType string) bool {
+	if level == LogTypeBehavior {
+		return true
+	}
+	levelConfig, ok := myLog.levels.Load().(*logLevelConfig)
+	if !ok {
+		return true
+	}
+	index, err := logLevelIndex(level)
+	if err != nil {
+		return true
+	}
+	if typeIndex, ok := levelConfig.types[logType]; ok {
+		return index >= typeIndex
+	}
+	return index >= levelConfig.level
+}
+
+//设置最低级别
+func (myLog *log) SetLevel(level string) error {
+	index, err := logLevelIndex(level)
+	if err != nil {
+		return err
+	}
+	myLog.levelLock.Lock()
+	defer myLog.levelLock.Unlock()
+	myLog.runtimeLevels().level = index
+	myLog.storeLevels()
+	return nil
+}
+
+//设置日志类型的最低级别,level为空时取消单独设置(包括app.toml中的)
+func (myLog *log) SetTypeLevel(logType string, level string) error {
+	index := -1
+	if level != "" {
+		var err error
+		index, err = logLevelIndex(level)
+		if err != nil {
+			return err
+		}
+	}
+	myLog.levelLock.Lock()
+	defer myLog.levelLock.Unlock()
+	myLog.runtimeLevels().types[logType] = index
+	myLog.storeLevels()
+	return nil
+}
+
+//清除运行时的设置,使用app.toml中的级别
+func (myLog *log) ResetLevels() {
+	myLog.levelLock.Lock()
+	defer myLog.levelLock.Unlock()
+	myLog.levelSet = nil
+	myLog.storeLevels()
+}
+
+//运行时的设置,类型级别为-1时表示取消单独设置,需要持有 levelLock
+func (myLog *log) runtimeLevels() *logLevelConfig {
+	if myLog.levelSet == nil {
+		myLog.levelSet = &logLevelConfig{level: -1, types: make(map[string]int)}
+	}
+	return myLog.levelSet
+}
+
+//合并配置和运行时的设置,需要持有 levelLock
+func (myLog *log) storeLevels() {
+	levelConfig := &logLevelConfig{types: make(map[string]int)}
+	if myLog.levelBase != nil {
+		levelConfig = myLog.levelBase.copy()
+	}
+	levelSet := myLog.runtimeLevels()
+	if levelSet.level >= 0 {
+		levelConfig.level = levelSet.level
+	}
+	for k, v := range levelSet.types {
+		if v < 0 {
+			delete(levelConfig.types, k)
+		} else {
+			levelConfig.types[k] = v
+		}
+	}
+	myLog.levels.Store(levelConfig)
+}
+
+//当前的级别设置,返回 最低级别 和 日志类型 => 级别
+func (myLog *log) Levels() (string, map[string]string) {
+	levelConfig := myLog.currentLevels()
+	types := make(map[string]string)
+	for k, v := range levelConfig.types {
+		types[k] = logLevels[v]
+	}
+	return logLevels[levelConfig.level], types
+}
+
+func (myLog *log) currentLevels() *logLevelConfig {
+	if levelConfig, ok := myLog.levels.Load().(*logLevelConfig); ok {
+		return levelConfig
+	}
+	return &logLevelConfig{types: make(map[string]int)}
+}
+
+//从 app.toml 重新读取级别配置
+func (app *app) ReloadLogLevel() error {
+	config := &struct {
+		Log *logConfig `toml:"log"`
+	}{Log: &logConfig{}}
+	if err := app.Env("app", config); err != nil {
+		return err
+	}
+	levelConfig, err := newLogLevelConfig(config.Log.Level, config.Log.Types)
+	if err != nil {
+		app.configError(err)
+		return err
+	}
+	app.Log.levelLock.Lock()
+	app.Log.levelBase = levelConfig
+	app.Log.storeLevels()
+	app.Log.levelLock.Unlock()
+	return nil
+}
+
+//收到信号时需要重新读取级别的应用
+var logLevelApps = make(map[*app]bool)
+var logLevelAppLock sync.Mutex
+var logLevelSignalOnce sync.Once
+
+//监听信号和配置文件变化重新读取级别
+func (app *app) watchLogLevel() {
+	app.WatchEnv("app", func(configFile string) {
+		_ = app.ReloadLogLevel()
+	})
+	logLevelAppLock.Lock()
+	logLevelApps[app] = true
+	logLevelAppLock.Unlock()
+	app.OnShutdown("log_level_signal", HookPriorityResource, func() error {
+		logLevelAppLock.Lock()
+		delete(logLevelApps, app)
+		logLevelAppLock.Unlock()
+		return nil
+	})
+	logLevelSignalOnce.Do(func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, LogLevelSignal)
+		go func() {
+			for range sig {
+				for _, v := range signalLogLevelApps() {
+					_ = v.ReloadLogLevel()
+				}
+			}
+		}()
+	})
+}
+
+func signalLogLevelApps() []*app {
+	logLevelAppLock.Lock()
+	defer logLevelAppLock.Unlock()
+	result := make([]*app, 0, len(logLevelApps))
+	for k := range logLevelApps {
+		result = append(result, k)
+	}
+	return result
+}
+
+//查看和修改默认应用日志级别的接口,需要业务自己做权限控制
+// GET 返回当前级别
+// POST level=warn 修改最低级别, level=debug&type=curl_error 修改类型级别, type=curl_error&level= 取消类型级别
+func LogLevelHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		myLog := App().Log
+		if c.Request.Method == http.MethodPost {
+			var err error
+			level := c.PostForm("level")
+			if logType := c.PostForm("type"); logType != "" {
+				err = myLog.SetTypeLevel(logType, level)
+			} else {
+				err = myLog.SetLevel(level)
+			}
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		level, types := myLog.Levels()
+		c.JSON(http.StatusOK, gin.H{"level": level, "types": types})
+	}
+}
@@ -0,0 +1,496 @@
+package frame
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// 日志查询
+// 按时间范围读取各级别的小时文件(包括压缩后的 .log.gz),过滤后按时间顺序输出
+// 命令行:
+//	./api log -since=30m -level=error,warn -type=redis_error
+//	./api log -from="2023-01-11 03:00" -to="2023-01-11 05:00" -requestId=abc
+//	./api log -field=route=/user/info -field=ip=10.0.0.1 -pid=1234
+//	./api log -level=error -follow     #输出历史后继续输出新日志,跨小时自动切换文件
+// 代码中:
+//	frame.App().Log.Query(&frame.LogQuery{From: from, To: to, Levels: []string{"error"}}, func(record *frame.LogRecord) bool {
+//		return true //返回false停止
+//	})
+// 支持 json logfmt text 三种格式,text 格式只能按时间 级别 类型 进程id 过滤
+
+//持续输出时检查新日志的间隔
+const logFollowInterval = 500 * time.Millisecond
+
+//查询条件,为空的条件不过滤
+type LogQuery struct {
+	From      time.Time         //开始时间,为空时为一小时前
+	To        time.Time         //结束时间,为空时为当前时间
+	Levels    []string          //级别,为空时为 debug info warn error
+	Types     []string          //日志类型
+	Pid       int               //进程id
+	RequestId string            //请求id
+	Fields    map[string]string //字段 => 值,先查附加字段再查msg中的字段
+	Limit     int               //最多返回的条数,0不限制
+}
+
+//一条日志
+type LogRecord struct {
+	Level  string
+	Type   string
+	Time   time.Time
+	Pid    int
+	Msg    interface{}
+	Fields map[string]interface{}
+	Line   string //原始内容
+	File   string //所在文件
+}
+
+//按时间顺序查询,handle返回false时停止
+func (myLog *log) Query(query *LogQuery, handle func(record *LogRecord) bool) error {
+	_, err := myLog.query(query, handle)
+	return err
+}
+
+//查询历史后继续输出新日志,stop关闭时返回
+func (myLog *log) Follow(query *LogQuery, stop <-chan bool, handle func(record *LogRecord) bool) error {
+	followQuery := *query
+	followQuery.To = myLog.layout.now()
+	followQuery.Limit = 0
+	offsets, err := myLog.query(&followQuery, handle)
+	if err != nil {
+		return err
+	}
+	tailQuery := *query
+	tailQuery.From, tailQuery.To = time.Time{}, time.Time{}
+	levels := query.levels()
+	current := make(map[string]string, len(levels))
+	now := myLog.layout.now()
+	for _, level := range levels {
+		current[level] = myLog.levelFile(level, now)
+	}
+	ticker := time.NewTicker(logFollowInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+		now := myLog.layout.now()
+		records := make([]*LogRecord, 0)
+		for _, level := range levels {
+			filePath := myLog.levelFile(level, now)
+			//跨小时后先读完旧文件剩余的内容
+			if filePath != current[level] {
+				records = append(records, myLog.readFrom(current[level], level, offsets, &tailQuery)...)
+				current[level] = filePath
+			}
+			records = append(records, myLog.readFrom(filePath, level, offsets, &tailQuery)...)
+		}
+		sortLogRecords(records)
+		for _, record := range records {
+			if !handle(record) {
+				return nil
+			}
+		}
+	}
+}
+
+//级别在某个时间的文件,行为日志有单独的目录
+func (myLog *log) levelFile(level string, now time.Time) string {
+	if level == LogTypeBehavior && myLog.behavior != nil {
+		return myLog.behavior.layout.filePath(level, now)
+	}
+	return myLog.layout.filePath(level, now)
+}
+
+//读取文件新增的完整行
+func (myLog *log) readFrom(filePath string, level string, offsets map[string]int64, query *LogQuery) []*LogRecord {
+	records := make([]*LogRecord, 0)
+	file, err := os.Open(filePath)
+	if err != nil {
+		return records
+	}
+	defer file.Close()
+	if _, err := file.Seek(offsets[filePath], io.SeekStart); err != nil {
+		return records
+	}
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			//没有换行的是还在写入的行,下次再读
+			break
+		}
+		offsets[filePath] += int64(len(line))
+		if record := myLog.parseRecord(line, level, filePath); record != nil && query.match(record) {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+//查询历史,返回读取过的文件的位置
+func (myLog *log) query(query *LogQuery, handle func(record *LogRecord) bool) (map[string]int64, error) {
+	offsets := make(map[string]int64)
+	to := query.To
+	if to.IsZero() {
+		to = myLog.layout.now()
+	}
+	from := query.From
+	if from.IsZero() {
+		from = to.Add(-time.Hour)
+	}
+	if from.After(to) {
+		return offsets, errors.New(LogQueryError.Error() + ":开始时间晚于结束时间")
+	}
+	rangeQuery := *query
+	rangeQuery.From, rangeQuery.To = from, to
+	levels := query.levels()
+	visited := make(map[string]bool)
+	count := 0
+	for hour := myLog.layout.hourStart(from); !hour.After(to); hour = hour.Add(time.Hour) {
+		records := make([]*LogRecord, 0)
+		for _, level := range levels {
+			filePath := myLog.levelFile(level, hour)
+			//路径模板没有小时时多个小时是同一个文件
+			if visited[filePath] {
+				continue
+			}
+			visited[filePath] = true
+			records = append(records, myLog.readFrom(filePath, level, offsets, &rangeQuery)...)
+			records = append(records, myLog.readGzip(filePath+".gz", level, &rangeQuery)...)
+		}
+		sortLogRecords(records)
+		for _, record := range records {
+			if !handle(record) {
+				return offsets, nil
+			}
+			count++
+			if query.Limit > 0 && count >= query.Limit {
+				return offsets, nil
+			}
+		}
+	}
+	return offsets, nil
+}
+
+//读取压缩后的文件
+func (myLog *log) readGzip(filePath string, level string, query *LogQuery) []*LogRecord {
+	records := make([]*LogRecord, 0)
+	file, err := os.Open(filePath)
+	if err != nil {
+		return records
+	}
+	defer file.Close()
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		return records
+	}
+	defer reader.Close()
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if record := myLog.parseRecord(scanner.Text(), level, filePath); record != nil && query.match(record) {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+func sortLogRecords(records []*LogRecord) {
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Time.Before(records[j].Time)
+	})
+}
+
+func (query *LogQuery) levels() []string {
+	if len(query.Levels) > 0 {
+		return query.Levels
+	}
+	return []string{LogTypeDebug, LogTypeInfo, LogTypeWarn, LogTypeError}
+}
+
+func (query *LogQuery) match(record *LogRecord) bool {
+	if !query.From.IsZero() && record.Time.Before(query.From) {
+		return false
+	}
+	if !query.To.IsZero() && record.Time.After(query.To) {
+		return false
+	}
+	if len(query.Types) > 0 && !inStringSlice(record.Type, query.Types) {
+		return false
+	}
+	if query.Pid > 0 && record.Pid != query.Pid {
+		return false
+	}
+	if query.RequestId != "" {
+		value, ok := record.field("request_id")
+		if !ok || value != query.RequestId {
+			return false
+		}
+	}
+	for key, expect := range query.Fields {
+		value, ok := record.field(key)
+		if !ok || value != expect {
+			return false
+		}
+	}
+	return true
+}
+
+//字段的值,先查附加字段再查msg中的字段
+func (record *LogRecord) field(key string) (string, bool) {
+	if value, ok := record.Fields[key]; ok {
+		return logValueString(value), true
+	}
+	if msg, ok := record.Msg.(map[string]interface{}); ok {
+		if value, ok := msg[key]; ok {
+			return logValueString(value), true
+		}
+	}
+	return "", false
+}
+
+//解析一行日志,无法解析时返回空
+func (myLog *log) parseRecord(line string, level string, filePath string) *LogRecord {
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return nil
+	}
+	record := &LogRecord{Level: level, Line: line, File: filePath}
+	var values map[string]interface{}
+	switch {
+	case strings.HasPrefix(line, "{"):
+		values = make(map[string]interface{})
+		if json.Unmarshal([]byte(line), &values) != nil {
+			return nil
+		}
+	case myLog.layout.format == LogFormatText:
+		values = parseLogText(line, strings.Count(myLog.layout.timeLayout, " ")+1)
+	default:
+		values = parseLogfmt(line)
+	}
+	if values == nil {
+		return nil
+	}
+	value := func(key string) string {
+		if v, ok := values[key]; ok && v != nil {
+			return logValueString(v)
+		}
+		return ""
+	}
+	record.Type = value("type")
+	record.Msg = values["msg"]
+	if pid, err := strconv.Atoi(value("pid")); err == nil {
+		record.Pid = pid
+	}
+	if fields, ok := values["fields"].(map[string]interface{}); ok {
+		record.Fields = fields
+	} else {
+		record.Fields = make(map[string]interface{})
+		for k, v := range values {
+			if !inStringSlice(k, []string{"time", "level", "type", "pid", "msg", "path"}) {
+				record.Fields[k] = v
+			}
+		}
+	}
+	//行为日志的类型在path或者event中,事件使用毫秒时间戳
+	if record.Type == "" {
+		record.Type = value("path")
+	}
+	if record.Type == "" {
+		record.Type = value("event")
+	}
+	if ts, ok := values["ts"].(float64); ok && values["time"] == nil {
+		record.Time = time.Unix(0, int64(ts)*int64(time.Millisecond)).In(myLog.layout.location)
+	} else {
+		record.Time = myLog.parseLogTime(value("time"))
+	}
+	return record
+}
+
+//按配置的格式解析时间,兼容没有补零的旧格式
+func (myLog *log) parseLogTime(value string) time.Time {
+	for _, timeLayout := range []string{myLog.layout.timeLayout, "2006-1-2 15:4:5", time.RFC3339Nano} {
+		if t, err := time.ParseInLocation(timeLayout, value, myLog.layout.location); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+//解析logfmt格式,msg为json时还原
+func parseLogfmt(line string) map[string]interface{} {
+	values := make(map[string]interface{})
+	for line != "" {
+		line = strings.TrimLeft(line, " ")
+		pos := strings.Index(line, "=")
+		if pos <= 0 {
+			break
+		}
+		key := line[:pos]
+		line = line[pos+1:]
+		var value string
+		if strings.HasPrefix(line, "\"") {
+			end := logfmtQuoteEnd(line)
+			if end < 0 {
+				return nil
+			}
+			value, _ = strconv.Unquote(line[:end+1])
+			line = line[end+1:]
+		} else if end := strings.Index(line, " "); end >= 0 {
+			value, line = line[:end], line[end:]
+		} else {
+			value, line = line, ""
+		}
+		values[key] = logJsonValue(value)
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	return values
+}
+
+//引号开头的值的结束位置,跳过转义的引号
+func logfmtQuoteEnd(value string) int {
+	for i := 1; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+//解析text格式: 时间 [级别] 类型 pid=进程id 内容
+func parseLogText(line string, timeParts int) map[string]interface{} {
+	parts := strings.SplitN(line, " ", timeParts+4)
+	if len(parts) < timeParts+3 || !strings.HasPrefix(parts[timeParts+2], "pid=") {
+		return nil
+	}
+	values := map[string]interface{}{
+		"time":  strings.Join(parts[:timeParts], " "),
+		"level": strings.Trim(parts[timeParts], "[]"),
+		"type":  parts[timeParts+1],
+		"pid":   strings.TrimPrefix(parts[timeParts+2], "pid="),
+	}
+	if len(parts) > timeParts+3 {
+		values["msg"] = logJsonValue(parts[timeParts+3])
+	}
+	return values
+}
+
+//json格式的值还原成map或数组
+func logJsonValue(value string) interface{} {
+	if strings.HasPrefix(value, "{") || strings.HasPrefix(value, "[") {
+		var result interface{}
+		if json.Unmarshal([]byte(value), &result) == nil {
+			return result
+		}
+	}
+	return value
+}
+
+//便于阅读的输出: 时间 [级别] 类型 pid 附加字段,下一行是缩进后的内容
+func (record *LogRecord) Pretty() string {
+	result := record.Time.Format(logTimeDefault) + " [" + record.Level + "] " + record.Type
+	if record.Pid > 0 {
+		result += " pid=" + strconv.Itoa(record.Pid)
+	}
+	for _, key := range sortedFieldKeys(record.Fields) {
+		result += " " + key + "=" + logfmtValue(record.Fields[key])
+	}
+	msg := logValueString(record.Msg)
+	switch record.Msg.(type) {
+	case nil:
+		msg = record.Line
+	case map[string]interface{}, []interface{}:
+		if indent, err := json.MarshalIndent(record.Msg, "    ", "  "); err == nil {
+			msg = string(indent)
+		}
+	}
+	return result + "\n    " + msg + "\n"
+}
+
+//log 命令
+func (app *app) logCommand(args []string) error {
+	myLog := app.Log
+	query := &LogQuery{
+		Pid:       FlagInt("pid"),
+		RequestId: FlagString("requestId"),
+		Limit:     FlagInt("limit"),
+		Fields:    make(map[string]string),
+	}
+	now := myLog.layout.now()
+	query.To = now
+	query.From = now.Add(-FlagDuration("since"))
+	var err error
+	if from := FlagString("from"); from != "" {
+		if query.From, err = myLog.parseQueryTime(from); err != nil {
+			return err
+		}
+	}
+	if to := FlagString("to"); to != "" {
+		if query.To, err = myLog.parseQueryTime(to); err != nil {
+			return err
+		}
+	}
+	if level := FlagString("level"); level != "" {
+		query.Levels = strings.Split(level, ",")
+	}
+	if logType := FlagString("type"); logType != "" {
+		query.Types = strings.Split(logType, ",")
+	}
+	for _, field := range FlagStrings("field") {
+		pos := strings.Index(field, "=")
+		if pos <= 0 {
+			return errors.New(LogQueryError.Error() + ":field 格式为 key=value:" + field)
+		}
+		query.Fields[field[:pos]] = field[pos+1:]
+	}
+	raw := FlagBool("raw")
+	handle := func(record *LogRecord) bool {
+		if raw {
+			fmt.Println(record.Line)
+		} else {
+			fmt.Print(record.Pretty())
+		}
+		return true
+	}
+	if !FlagBool("follow") {
+		return myLog.Query(query, handle)
+	}
+	stop := make(chan bool)
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sig)
+	go func() {
+		<-sig
+		close(stop)
+	}()
+	return myLog.Follow(query, stop, handle)
+}
+
+//命令行中的时间,使用日志的时区
+func (myLog *log) parseQueryTime(value string) (time.Time, error) {
+	for _, timeLayout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02 15", "2006-01-02", time.RFC3339} {
+		if t, err := time.ParseInLocation(timeLayout, value, myLog.layout.location); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New(LogQueryError.Error() + ":时间格式错误:" + value)
+}
@@ -0,0 +1,122 @@
+package frame
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseLogfmt(t *testing.T) {
+	tests := []struct {
+		line string
+		want map[string]interface{}
+	}{
+		{
+			line: `time="2026-10-18 11:02:03" level=error type=mysql_error pid=12 msg=timeout`,
+			want: map[string]interface{}{"time": "2026-10-18 11:02:03", "level": "error", "type": "mysql_error", "pid": "12", "msg": "timeout"},
+		},
+		{
+			line: `level=warn msg="say \"hi\" a=b" user=7`,
+			want: map[string]interface{}{"level": "warn", "msg": `say "hi" a=b`, "user": "7"},
+		},
+		{
+			line: `msg="{\"error\":\"e\",\"code\":3}" empty=""`,
+			want: map[string]interface{}{"msg": map[string]interface{}{"error": "e", "code": float64(3)}, "empty": ""},
+		},
+		{
+			line: `msg="line\nbreak"`,
+			want: map[string]interface{}{"msg": "line\nbreak"},
+		},
+		{line: `msg="unterminated`},
+		{line: `no pairs here`},
+		{line: ``},
+	}
+	for _, test := range tests {
+		if got := parseLogfmt(test.line); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s 解析为 %#v,需要 %#v", test.line, got, test.want)
+		}
+	}
+}
+
+func TestParseLogText(t *testing.T) {
+	tests := []struct {
+		line      string
+		timeParts int
+		want      map[string]interface{}
+	}{
+		{
+			line:      `2026-10-18 11:02:03 [error] mysql_error pid=12 connect refused`,
+			timeParts: 2,
+			want:      map[string]interface{}{"time": "2026-10-18 11:02:03", "level": "error", "type": "mysql_error", "pid": "12", "msg": "connect refused"},
+		},
+		{
+			line:      `2026-10-18T11:02:03.000+08:00 [warn] slow pid=1 {"sql":"select 1"}`,
+			timeParts: 1,
+			want:      map[string]interface{}{"time": "2026-10-18T11:02:03.000+08:00", "level": "warn", "type": "slow", "pid": "1", "msg": map[string]interface{}{"sql": "select 1"}},
+		},
+		{
+			line:      `2026-10-18 11:02:03 [info] empty pid=1`,
+			timeParts: 2,
+			want:      map[string]interface{}{"time": "2026-10-18 11:02:03", "level": "info", "type": "empty", "pid": "1"},
+		},
+		{line: `2026-10-18 11:02:03 [info] missing pid`, timeParts: 2},
+		{line: `short`, timeParts: 2},
+	}
+	for _, test := range tests {
+		if got := parseLogText(test.line, test.timeParts); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s 解析为 %#v,需要 %#v", test.line, got, test.want)
+		}
+	}
+}
+
+//按配置的格式写出一行再解析回来
+func TestLogRecordRoundTrip(t *testing.T) {
+	tests := []struct {
+		format     string
+		timeFormat string
+		fields     map[string]interface{}
+	}{
+		{format: LogFormatJson, fields: map[string]interface{}{"request_id": "r1"}},
+		{format: LogFormatLogfmt, fields: map[string]interface{}{"request_id": "r 1"}},
+		{format: LogFormatLogfmt, timeFormat: "rfc3339"},
+		{format: LogFormatText},
+		{format: LogFormatText, timeFormat: "rfc3339"},
+	}
+	for _, test := range tests {
+		t.Run(test.format+"/"+test.timeFormat, func(t *testing.T) {
+			testApp, clean := newTestApp(t, map[string]string{
+				"develop/app.toml": "[log]\npath = \"{dir}/logs\"\nformat = \"" + test.format + "\"\ntimeFormat = \"" + test.timeFormat + "\"\n",
+			})
+			defer clean()
+			myLog := testApp.Log
+			now := time.Date(2026, 10, 18, 9, 5, 7, 0, myLog.layout.location)
+			msg := map[string]interface{}{"error": "a \"quoted\" = value", "code": float64(3)}
+			line, err := myLog.layout.formatLine(&logTpl{
+				Level:  LogTypeError,
+				Msg:    msg,
+				Time:   myLog.layout.formatTime(now),
+				Type:   "mysql_error",
+				Pid:    42,
+				Fields: test.fields,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			record := myLog.parseRecord(line, LogTypeError, "")
+			if record == nil {
+				t.Fatalf("无法解析 %s", line)
+			}
+			if record.Type != "mysql_error" || record.Pid != 42 || !record.Time.Equal(now) {
+				t.Fatalf("解析结果错误 %+v", record)
+			}
+			if !reflect.DeepEqual(record.Msg, msg) {
+				t.Fatalf("msg %#v,需要 %#v", record.Msg, msg)
+			}
+			for k, v := range test.fields {
+				if record.Fields[k] != v {
+					t.Fatalf("字段 %s 为 %v,需要 %v", k, record.Fields[k], v)
+				}
+			}
+		})
+	}
+}
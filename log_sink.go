@@ -0,0 +1,479 @@
+package frame
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/syslog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 日志输出
+// 默认只写本地文件,app.toml 中配置 [[log.sinks]] 后按配置输出,需要本地文件时要配置 file
+//	[[log.sinks]]
+//	type = "file"
+//	[[log.sinks]]
+//	type = "stdout"                  #容器中输出json到标准输出
+//	levels = ["error", "warn"]       #只输出这些级别,为空全部输出
+//	[[log.sinks]]
+//	type = "syslog"
+//	network = "udp"                  #为空使用本机syslog
+//	address = "127.0.0.1:514"
+//	tag = "api"
+//	[[log.sinks]]
+//	type = "udp"
+//	address = "10.0.0.1:9000"
+//	types = ["mysql_error", "redis_error"] #只输出这些日志类型,为空全部输出
+//	[[log.sinks]]
+//	type = "http"
+//	url = "http://collector/logs"    #每行日志POST一次
+//	timeout = 3
+// 自定义输出通过 RegisterLogSink 注册类型后在配置中使用
+// 文件以外的输出都有独立的缓冲和协程,写入失败或者阻塞不影响其他输出和业务,失败信息输出到标准错误
+// 建立输出失败(如syslog连接不上)时不影响启动,该输出暂停,之后有日志时再重试
+// levels 只能是 debug info warn error,类型不存在或者级别错误时启动报错
+
+const LogSinkFile = "file"
+const LogSinkStdout = "stdout"
+const LogSinkSyslog = "syslog"
+const LogSinkUdp = "udp"
+const LogSinkHttp = "http"
+
+//单个输出的缓冲行数
+const logSinkBufferSize = 1000
+
+//连续失败多少次后暂停输出
+const logSinkMaxFailures = 5
+
+//暂停输出的时间
+const logSinkPause = 10 * time.Second
+
+//日志输出接口
+type LogSink interface {
+	Write(entry *LogEntry) error
+	Close() error
+}
+
+//一行日志
+type LogEntry struct {
+	Level string    //级别
+	Type  string    //日志类型
+	Time  time.Time //记录时间
+	Line  string    //格式化后的内容,以换行结尾
+}
+
+//[[log.sinks]] 配置
+type LogSinkConfig struct {
+	Name    string   `toml:"name"` //名称,用于统计,默认使用类型
+	Type    string   `toml:"type" validate:"required"`
+	Levels  []string `toml:"levels"`
+	Types   []string `toml:"types"`
+	Network string   `toml:"network"`
+	Address string   `toml:"address"`
+	Url     string   `toml:"url"`
+	Tag     string   `toml:"tag"`
+	Timeout int      `toml:"timeout" validate:"min=0"` //网络超时(秒),默认3
+}
+
+//单个输出的统计
+type LogSinkStats struct {
+	Written  uint64 //写入成功
+	Failed   uint64 //写入失败
+	Dropped  uint64 //缓冲满或者暂停时丢弃
+	Disabled bool   //建立输出失败,等待重试
+}
+
+var logSinkFactories = map[string]func(config *LogSinkConfig) (LogSink, error){
+	LogSinkStdout: newStdoutSink,
+	LogSinkSyslog: newSyslogSink,
+	LogSinkUdp:    newUdpSink,
+	LogSinkHttp:   newHttpSink,
+}
+var logSinkLock sync.RWMutex
+
+//注册自定义输出类型,需要在 App().Init 之前注册
+func RegisterLogSink(sinkType string, factory func(config *LogSinkConfig) (LogSink, error)) {
+	logSinkLock.Lock()
+	defer logSinkLock.Unlock()
+	logSinkFactories[sinkType] = factory
+}
+
+func getLogSinkFactory(sinkType string) (func(config *LogSinkConfig) (LogSink, error), bool) {
+	logSinkLock.RLock()
+	defer logSinkLock.RUnlock()
+	factory, ok := logSinkFactories[sinkType]
+	return factory, ok
+}
+
+type logSinkRoute struct {
+	name   string
+	levels []string
+	types  []string
+	sink   LogSink
+	stats  *LogSinkStats
+}
+
+func (route *logSinkRoute) match(level string, logType string) bool {
+	if len(route.levels) > 0 && !inStringSlice(level, route.levels) {
+		return false
+	}
+	if len(route.types) > 0 && !inStringSlice(logType, route.types) {
+		return false
+	}
+	return true
+}
+
+//根据配置建立输出,没有配置时只写文件
+// appName 作为没有配置tag时的默认tag
+func (myLog *log) newSinks(appName string) ([]*logSinkRoute, error) {
+	configs := myLog.config.Sinks
+	if len(configs) == 0 {
+		configs = []*LogSinkConfig{{Type: LogSinkFile}}
+	}
+	routes := make([]*logSinkRoute, 0, len(configs))
+	for _, config := range configs {
+		route := &logSinkRoute{name: config.Name, levels: config.Levels, types: config.Types, stats: &LogSinkStats{}}
+		if route.name == "" {
+			route.name = config.Type
+		}
+		for _, level := range config.Levels {
+			if !inStringSlice(level, logLevels[:len(logLevels)-1]) {
+				return nil, errors.New(LogSinkError.Error() + ":" + route.name + ":级别错误 " + level)
+			}
+		}
+		if config.Type == LogSinkFile {
+			route.sink = &fileSink{log: myLog}
+		} else {
+			factory, ok := getLogSinkFactory(config.Type)
+			if !ok {
+				return nil, errors.New(LogSinkError.Error() + ":不支持的类型 " + config.Type)
+			}
+			sinkConfig := *config
+			if sinkConfig.Tag == "" {
+				sinkConfig.Tag = appName
+			}
+			route.sink = newIsolatedSink(route.name, func() (LogSink, error) {
+				return factory(&sinkConfig)
+			}, route.stats)
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+//是否输出到本地文件
+func (config *logConfig) hasFileSink() bool {
+	if len(config.Sinks) == 0 {
+		return true
+	}
+	for _, v := range config.Sinks {
+		if v.Type == LogSinkFile {
+			return true
+		}
+	}
+	return false
+}
+
+//写入所有匹配的输出
+func (myLog *log) writeSinks(entry *LogEntry) {
+	for _, route := range myLog.sinks {
+		if !route.match(entry.Level, entry.Type) {
+			continue
+		}
+		if err := route.sink.Write(entry); err != nil {
+			atomic.AddUint64(&route.stats.Failed, 1)
+		} else if _, ok := route.sink.(*fileSink); ok {
+			atomic.AddUint64(&route.stats.Written, 1)
+		}
+	}
+}
+
+//关闭文件以外的输出
+func (myLog *log) closeSinks() {
+	for _, route := range myLog.sinks {
+		if _, ok := route.sink.(*fileSink); !ok {
+			_ = route.sink.Close()
+		}
+	}
+}
+
+//每个输出的统计,名称 => 统计
+func (myLog *log) SinkStats() map[string]LogSinkStats {
+	result := make(map[string]LogSinkStats)
+	for _, route := range myLog.sinks {
+		stats := LogSinkStats{
+			Written: atomic.LoadUint64(&route.stats.Written),
+			Failed:  atomic.LoadUint64(&route.stats.Failed),
+			Dropped: atomic.LoadUint64(&route.stats.Dropped),
+		}
+		if isolated, ok := route.sink.(*isolatedSink); ok {
+			stats.Disabled = atomic.LoadInt32(&isolated.disabled) == 1
+		}
+		result[route.name] = stats
+	}
+	return result
+}
+
+//本地文件,异步写入已关闭时直接写入
+type fileSink struct {
+	log *log
+}
+
+func (sink *fileSink) Write(entry *LogEntry) error {
+	if sink.log.writer == nil || !sink.log.writer.write(entry.Level, entry.Time, entry.Line) {
+		sink.log.writeSync(entry.Level, entry.Time, entry.Line)
+	}
+	return nil
+}
+
+func (sink *fileSink) Close() error {
+	return nil
+}
+
+//独立缓冲和协程的输出,连续失败后暂停一段时间
+// 建立输出失败时同样暂停,之后在协程中重新建立
+type isolatedSink struct {
+	name       string
+	sink       LogSink                 //建立失败时为空
+	factory    func() (LogSink, error) //建立输出
+	disabled   int32                   //建立失败为1
+	stats      *LogSinkStats
+	entries    chan *LogEntry
+	lock       sync.RWMutex
+	closed     bool
+	doneChan   chan bool
+	failures   int
+	pauseUntil time.Time
+}
+
+func newIsolatedSink(name string, factory func() (LogSink, error), stats *LogSinkStats) *isolatedSink {
+	isolated := &isolatedSink{
+		name:     name,
+		factory:  factory,
+		stats:    stats,
+		entries:  make(chan *LogEntry, logSinkBufferSize),
+		doneChan: make(chan bool),
+	}
+	isolated.open()
+	go isolated.run()
+	return isolated
+}
+
+//建立输出,失败时暂停一段时间,报错和panic都作为失败
+func (isolated *isolatedSink) open() bool {
+	sink, err := func() (sink LogSink, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("%v", r)
+			}
+		}()
+		return isolated.factory()
+	}()
+	if err != nil {
+		atomic.AddUint64(&isolated.stats.Failed, 1)
+		atomic.StoreInt32(&isolated.disabled, 1)
+		fmt.Fprintln(os.Stderr, "日志输出建立失败,暂停", isolated.name, logSinkPause, err)
+		isolated.pauseUntil = time.Now().Add(logSinkPause)
+		return false
+	}
+	isolated.lock.Lock()
+	isolated.sink = sink
+	isolated.lock.Unlock()
+	atomic.StoreInt32(&isolated.disabled, 0)
+	return true
+}
+
+func (isolated *isolatedSink) Write(entry *LogEntry) error {
+	isolated.lock.RLock()
+	defer isolated.lock.RUnlock()
+	if isolated.closed {
+		atomic.AddUint64(&isolated.stats.Dropped, 1)
+		return nil
+	}
+	select {
+	case isolated.entries <- entry:
+	default:
+		atomic.AddUint64(&isolated.stats.Dropped, 1)
+	}
+	return nil
+}
+
+func (isolated *isolatedSink) run() {
+	defer close(isolated.doneChan)
+	for entry := range isolated.entries {
+		if time.Now().Before(isolated.pauseUntil) {
+			atomic.AddUint64(&isolated.stats.Dropped, 1)
+			continue
+		}
+		if isolated.sink == nil && !isolated.open() {
+			atomic.AddUint64(&isolated.stats.Dropped, 1)
+			continue
+		}
+		err := isolated.write(entry)
+		if err == nil {
+			isolated.failures = 0
+			atomic.AddUint64(&isolated.stats.Written, 1)
+			continue
+		}
+		atomic.AddUint64(&isolated.stats.Failed, 1)
+		isolated.failures++
+		if isolated.failures == 1 {
+			fmt.Fprintln(os.Stderr, "日志输出失败", isolated.name, err)
+		}
+		if isolated.failures >= logSinkMaxFailures {
+			fmt.Fprintln(os.Stderr, "日志输出连续失败,暂停", isolated.name, logSinkPause)
+			isolated.failures = 0
+			isolated.pauseUntil = time.Now().Add(logSinkPause)
+		}
+	}
+}
+
+//单次写入,报错和panic都作为失败
+func (isolated *isolatedSink) write(entry *LogEntry) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	return isolated.sink.Write(entry)
+}
+
+//写完缓冲后关闭
+func (isolated *isolatedSink) Close() error {
+	isolated.lock.Lock()
+	if isolated.closed {
+		isolated.lock.Unlock()
+		return nil
+	}
+	isolated.closed = true
+	close(isolated.entries)
+	isolated.lock.Unlock()
+	select {
+	case <-isolated.doneChan:
+	case <-time.After(hookTimeout):
+	}
+	isolated.lock.RLock()
+	sink := isolated.sink
+	isolated.lock.RUnlock()
+	if sink == nil {
+		return nil
+	}
+	return sink.Close()
+}
+
+//标准输出
+type stdoutSink struct {
+	lock sync.Mutex
+}
+
+func newStdoutSink(config *LogSinkConfig) (LogSink, error) {
+	return &stdoutSink{}, nil
+}
+
+func (sink *stdoutSink) Write(entry *LogEntry) error {
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	_, err := os.Stdout.WriteString(entry.Line)
+	return err
+}
+
+func (sink *stdoutSink) Close() error {
+	return nil
+}
+
+//syslog,级别对应syslog的严重程度
+type syslogSink struct {
+	writer *syslog.Writer
+}
+
+func newSyslogSink(config *LogSinkConfig) (LogSink, error) {
+	writer, err := syslog.Dial(config.Network, config.Address, syslog.LOG_INFO|syslog.LOG_USER, config.Tag)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogSink{writer: writer}, nil
+}
+
+func (sink *syslogSink) Write(entry *LogEntry) error {
+	line := strings.TrimRight(entry.Line, "\n")
+	switch entry.Level {
+	case LogTypeError:
+		return sink.writer.Err(line)
+	case LogTypeWarn:
+		return sink.writer.Warning(line)
+	case LogTypeDebug:
+		return sink.writer.Debug(line)
+	default:
+		return sink.writer.Info(line)
+	}
+}
+
+func (sink *syslogSink) Close() error {
+	return sink.writer.Close()
+}
+
+//udp,每行一个数据包
+type udpSink struct {
+	conn net.Conn
+}
+
+func newUdpSink(config *LogSinkConfig) (LogSink, error) {
+	if config.Address == "" {
+		return nil, errors.New("address 不能为空")
+	}
+	conn, err := net.Dial("udp", config.Address)
+	if err != nil {
+		return nil, err
+	}
+	return &udpSink{conn: conn}, nil
+}
+
+func (sink *udpSink) Write(entry *LogEntry) error {
+	_, err := sink.conn.Write([]byte(entry.Line))
+	return err
+}
+
+func (sink *udpSink) Close() error {
+	return sink.conn.Close()
+}
+
+//http,每行POST一次
+// 不使用Curl,避免请求失败的日志再次进入输出
+type httpSink struct {
+	url    string
+	client *http.Client
+}
+
+func newHttpSink(config *LogSinkConfig) (LogSink, error) {
+	if config.Url == "" {
+		return nil, errors.New("url 不能为空")
+	}
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = 3
+	}
+	return &httpSink{url: config.Url, client: &http.Client{Timeout: time.Duration(timeout) * time.Second}}, nil
+}
+
+func (sink *httpSink) Write(entry *LogEntry) error {
+	resp, err := sink.client.Post(sink.url, "application/json", bytes.NewBufferString(entry.Line))
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return errors.New(HttpFailError.Error() + ":" + resp.Status)
+	}
+	return nil
+}
+
+func (sink *httpSink) Close() error {
+	sink.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,140 @@
+package frame
+
+import (
+	"errors"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// 报错日志的调用位置和堆栈
+// 框架内部的报错(redis mysql memcached curl server 等)默认带上完整堆栈,可以按日志类型和级别设置:
+//	[log]
+//	stack = "caller"          #off 不记录,caller 只记录调用位置,full 完整堆栈,默认full
+//	[log.stackLevels]
+//	warn = "off"
+//	[log.stackTypes]
+//	redis_error = "full"      #日志类型优先于级别
+//	mysql_slow = "caller"
+// caller 为框架外部的第一个调用位置,格式 文件:行号:函数,记录在 caller 字段,full 记录在 stack 字段
+
+const LogStackOff = "off"
+const LogStackCaller = "caller"
+const LogStackFull = "full"
+
+//查找调用位置时最多向上查找的层数
+const logCallerDepth = 32
+
+//获取堆栈的方法自身,查找调用位置时跳过
+var logStackFuncs = []string{"logCaller", "(*log).withStack", "(*log).logStack", "(*log).errorStack", "(*log).warnStack"}
+
+//框架的包名前缀,如 github.com/xxx/frame.
+var framePackage = func() string {
+	name := runtime.FuncForPC(reflect.ValueOf(checkStackConfig).Pointer()).Name()
+	slash := strings.LastIndex(name, "/")
+	return name[:slash+1+strings.Index(name[slash+1:], ".")+1]
+}()
+
+//检查配置的策略
+func checkStackConfig(config *logConfig) error {
+	policies := map[string]string{"stack": config.Stack}
+	for k, v := range config.StackLevels {
+		policies["stackLevels."+k] = v
+	}
+	for k, v := range config.StackTypes {
+		policies["stackTypes."+k] = v
+	}
+	for key, policy := range policies {
+		switch policy {
+		case "", LogStackOff, LogStackCaller, LogStackFull:
+		default:
+			return errors.New(LogStackError.Error() + ":" + key + "=" + policy)
+		}
+	}
+	return nil
+}
+
+//日志类型和级别对应的策略
+func (myLog *log) stackPolicy(level string, logType string) string {
+	config := myLog.config
+	if config == nil {
+		return LogStackFull
+	}
+	if policy, ok := config.StackTypes[logType]; ok {
+		return policy
+	}
+	if policy, ok := config.StackLevels[level]; ok {
+		return policy
+	}
+	if config.Stack != "" {
+		return config.Stack
+	}
+	return LogStackFull
+}
+
+//按策略在msg中加上调用位置或者堆栈
+func (myLog *log) withStack(msg map[string]interface{}, level string, logType string) map[string]interface{} {
+	switch myLog.stackPolicy(level, logType) {
+	case LogStackCaller:
+		msg["caller"] = logCaller()
+	case LogStackFull:
+		msg["stack"] = string(debug.Stack())
+	}
+	return msg
+}
+
+//记录错误,按策略带上调用位置或者堆栈
+func (myLog *log) errorStack(msg map[string]interface{}, logType string) {
+	myLog.logStack(LogTypeError, msg, logType)
+}
+
+//记录警告,按策略带上调用位置或者堆栈
+func (myLog *log) warnStack(msg map[string]interface{}, logType string) {
+	myLog.logStack(LogTypeWarn, msg, logType)
+}
+
+//级别过滤和重复抑制之后才获取堆栈,不记录的日志不需要获取
+func (myLog *log) logStack(level string, msg map[string]interface{}, logType string) {
+	tpl := &logTpl{Level: level, Msg: msg, Type: logType}
+	now, ok := myLog.accept(tpl)
+	if !ok {
+		return
+	}
+	myLog.withStack(msg, level, logType)
+	myLog.output(tpl, now)
+}
+
+//框架外部的第一个调用位置,都在框架内部时返回记录日志的位置
+func logCaller() string {
+	pcs := make([]uintptr, logCallerDepth)
+	n := runtime.Callers(1, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	first := ""
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, "runtime.") && !isLogStackFunc(frame.Function) {
+			caller := frame.File + ":" + strconv.Itoa(frame.Line) + ":" + frame.Function
+			if first == "" {
+				first = caller
+			}
+			if !strings.HasPrefix(frame.Function, framePackage) {
+				return caller
+			}
+		}
+		if !more {
+			break
+		}
+	}
+	return first
+}
+
+func isLogStackFunc(function string) bool {
+	for _, v := range logStackFuncs {
+		if function == framePackage+v {
+			return true
+		}
+	}
+	return false
+}
@@ -12,3 +12,5 @@ const LogCacheError = "cache_error"
 const LogCounterError = "counter_error"
 const LogQueueError = "queue_error"
 const LogServerError = "server_error"
+const LogLifecycleError = "lifecycle_error"
+const LogBehaviorError = "behavior_error"
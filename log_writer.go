@@ -0,0 +1,281 @@
+package frame
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 异步日志写入
+// 日志先放入缓冲通道,由后台协程批量写入,每个级别保持当前小时文件打开,跨小时后切换文件
+// 缓冲满时丢弃并计数,通过 frame.App().Log.Stats() 查看
+// 应用关闭时(关闭钩子)写入缓冲中剩余的日志,之后的日志直接同步写入
+// app.toml 中配置:
+//	[log]
+//	syncWrite = false   #改为true关闭异步写入
+//	bufferSize = 10000  #缓冲行数
+//	flushInterval = 1000 #刷盘间隔(毫秒)
+
+//默认缓冲行数
+const logBufferSize = 10000
+
+//默认刷盘间隔(毫秒)
+const logFlushInterval = 1000
+
+//单次批量写入的最大行数
+const logBatchSize = 512
+
+type logLine struct {
+	level string
+	time  time.Time
+	msg   string
+}
+
+//日志写入统计
+type LogStats struct {
+	Written        uint64            //已写入行数
+	Dropped        uint64            //缓冲满丢弃的行数
+	DroppedByLevel map[string]uint64 //每个级别丢弃的行数
+	Buffered       int               //缓冲中等待写入的行数
+}
+
+type logWriter struct {
+	written   uint64 //原子操作,放在开头保证对齐
+	dropped   uint64
+	log       *log
+	lines     chan *logLine
+	flushChan chan chan bool
+	stopChan  chan bool
+	doneChan  chan bool
+	lock      sync.RWMutex //关闭时阻止继续写入通道
+	closed    bool
+	files     map[string]*logOpenFile //级别 => 当前打开的文件
+	dropLock  sync.Mutex
+	dropLevel map[string]uint64
+}
+
+type logOpenFile struct {
+	path   string
+	file   *os.File
+	writer *bufio.Writer
+}
+
+func newLogWriter(log *log) *logWriter {
+	bufferSize := log.config.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = logBufferSize
+	}
+	return &logWriter{
+		log:       log,
+		lines:     make(chan *logLine, bufferSize),
+		flushChan: make(chan chan bool),
+		stopChan:  make(chan bool),
+		doneChan:  make(chan bool),
+		files:     make(map[string]*logOpenFile),
+		dropLevel: make(map[string]uint64),
+	}
+}
+
+func (writer *logWriter) start() {
+	interval := writer.log.config.FlushInterval
+	if interval <= 0 {
+		interval = logFlushInterval
+	}
+	go func() {
+		defer close(writer.doneChan)
+		ticker := time.NewTicker(time.Duration(interval) * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case line := <-writer.lines:
+				writer.writeBatch(line)
+			case <-ticker.C:
+				writer.flush()
+				writer.closeExpired(time.Now())
+			case done := <-writer.flushChan:
+				writer.drain()
+				writer.flush()
+				done <- true
+			case <-writer.stopChan:
+				writer.drain()
+				writer.flush()
+				writer.closeAll()
+				return
+			}
+		}
+	}()
+}
+
+//放入缓冲,返回false表示已经关闭需要同步写入
+func (writer *logWriter) write(level string, now time.Time, msg string) bool {
+	writer.lock.RLock()
+	defer writer.lock.RUnlock()
+	if writer.closed {
+		return false
+	}
+	select {
+	case writer.lines <- &logLine{level: level, time: now, msg: msg}:
+	default:
+		atomic.AddUint64(&writer.dropped, 1)
+		writer.dropLock.Lock()
+		writer.dropLevel[level]++
+		writer.dropLock.Unlock()
+	}
+	return true
+}
+
+//写入一行以及缓冲中已有的行,最多 logBatchSize 行后刷盘
+func (writer *logWriter) writeBatch(line *logLine) {
+	writer.writeLine(line)
+	for i := 1; i < logBatchSize; i++ {
+		select {
+		case line := <-writer.lines:
+			writer.writeLine(line)
+		default:
+			writer.flush()
+			return
+		}
+	}
+	writer.flush()
+}
+
+//写入缓冲中所有的行
+func (writer *logWriter) drain() {
+	for {
+		select {
+		case line := <-writer.lines:
+			writer.writeLine(line)
+		default:
+			return
+		}
+	}
+}
+
+func (writer *logWriter) writeLine(line *logLine) {
+	file, err := writer.getFile(line.level, line.time)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if _, err := file.writer.WriteString(line.msg); err != nil {
+		fmt.Println(err)
+		return
+	}
+	atomic.AddUint64(&writer.written, 1)
+}
+
+//当前小时的文件,跨小时后关闭旧文件打开新文件
+func (writer *logWriter) getFile(level string, now time.Time) (*logOpenFile, error) {
+	filePath := writer.log.layout.filePath(level, now)
+	if file, ok := writer.files[level]; ok {
+		if file.path == filePath {
+			return file, nil
+		}
+		file.close()
+		delete(writer.files, level)
+	}
+	if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0777)
+	if err != nil {
+		return nil, err
+	}
+	file := &logOpenFile{path: filePath, file: f, writer: bufio.NewWriterSize(f, 64*1024)}
+	writer.files[level] = file
+	return file, nil
+}
+
+func (writer *logWriter) flush() {
+	for _, file := range writer.files {
+		_ = file.writer.Flush()
+	}
+}
+
+//关闭已经不是当前小时的文件,便于清理和压缩
+func (writer *logWriter) closeExpired(now time.Time) {
+	for level, file := range writer.files {
+		if file.path != writer.log.layout.filePath(level, now) {
+			file.close()
+			delete(writer.files, level)
+		}
+	}
+}
+
+func (writer *logWriter) closeAll() {
+	for level, file := range writer.files {
+		file.close()
+		delete(writer.files, level)
+	}
+}
+
+func (file *logOpenFile) close() {
+	_ = file.writer.Flush()
+	_ = file.file.Close()
+}
+
+//写入缓冲中的日志,等待写入完成
+func (writer *logWriter) flushWait() {
+	writer.lock.RLock()
+	closed := writer.closed
+	writer.lock.RUnlock()
+	if closed {
+		return
+	}
+	done := make(chan bool, 1)
+	select {
+	case writer.flushChan <- done:
+		<-done
+	case <-writer.doneChan:
+	}
+}
+
+//关闭异步写入,写完缓冲中的日志
+func (writer *logWriter) stop() {
+	writer.lock.Lock()
+	if writer.closed {
+		writer.lock.Unlock()
+		return
+	}
+	writer.closed = true
+	writer.lock.Unlock()
+	close(writer.stopChan)
+	<-writer.doneChan
+}
+
+func (writer *logWriter) stats() *LogStats {
+	stats := &LogStats{
+		Written:        atomic.LoadUint64(&writer.written),
+		Dropped:        atomic.LoadUint64(&writer.dropped),
+		DroppedByLevel: make(map[string]uint64),
+		Buffered:       len(writer.lines),
+	}
+	writer.dropLock.Lock()
+	for k, v := range writer.dropLevel {
+		stats.DroppedByLevel[k] = v
+	}
+	writer.dropLock.Unlock()
+	return stats
+}
+
+//写入缓冲中的日志,同步写入时不做处理
+func (myLog *log) Flush() {
+	if myLog.writer != nil {
+		myLog.writer.flushWait()
+	}
+	if myLog.behavior != nil {
+		myLog.behavior.Flush()
+	}
+}
+
+//异步写入的统计,同步写入时为空统计
+func (myLog *log) Stats() *LogStats {
+	if myLog.writer == nil {
+		return &LogStats{DroppedByLevel: make(map[string]uint64)}
+	}
+	return myLog.writer.stats()
+}
@@ -1,33 +1,28 @@
 package frame
 
 import (
+	"context"
 	"github.com/bradfitz/gomemcache/memcache"
-	"runtime/debug"
-	"sync"
 )
 
 // memcached 基础结构体  实现了很多mc的基础方法
 // 需要不断新增和完善
 // 不直接对外服务 通过定义新的结构体继承来对外提供服务
 type memcached struct {
-	mc        *memcache.Client
-	mcOnce    sync.Once
 	GroupName string
+	App       *app            //所属应用,为空使用默认应用
+	ctx       context.Context //报错日志使用的上下文
 }
 
+//每次都从连接池缓存中获取,配置变更重建连接池后可以立即使用新的连接池
 func (mc *memcached) getPool() *memcache.Client {
-	if mc.mc == nil {
-		mc.mcOnce.Do(func() {
-			mc.mc = getMc(mc.GroupName)
-		})
-	}
-	return mc.mc
+	return getApp(mc.App).getMc(mc.GroupName)
 }
 
 func (mc *memcached) Get(key string) (string, error) {
 	result, err := mc.getPool().Get(key)
 	defer func() {
-		mcError(err)
+		mcError(contextLog(mc.App, mc.ctx), err)
 	}()
 	if err != nil {
 		if err == memcache.ErrCacheMiss {
@@ -46,7 +41,7 @@ func (mc *memcached) Set(key string, value interface{}, ttl ...int) bool {
 	}
 	err := mc.getPool().Set(&memcache.Item{Key: key, Value: []byte(convertToString(value)), Expiration: int32(ttlTime)})
 	defer func() {
-		mcError(err)
+		mcError(contextLog(mc.App, mc.ctx), err)
 	}()
 	if err != nil {
 		return false
@@ -57,7 +52,7 @@ func (mc *memcached) Set(key string, value interface{}, ttl ...int) bool {
 func (mc *memcached) Delete(key string) bool {
 	err := mc.getPool().Delete(key)
 	defer func() {
-		mcError(err)
+		mcError(contextLog(mc.App, mc.ctx), err)
 	}()
 	if err != nil {
 		if err == memcache.ErrCacheMiss {
@@ -76,7 +71,7 @@ func (mc *memcached) Increment(key string, options ...int) (int, error) {
 	}
 	res, err := mc.getPool().Increment(key, uint64(step))
 	defer func() {
-		mcError(err)
+		mcError(contextLog(mc.App, mc.ctx), err)
 	}()
 	if err != nil {
 		if err != memcache.ErrCacheMiss {
@@ -99,7 +94,7 @@ func (mc *memcached) Decrement(key string, options ...int) (int, error) {
 	}
 	res, err := mc.getPool().Decrement(key, uint64(step))
 	defer func() {
-		mcError(err)
+		mcError(contextLog(mc.App, mc.ctx), err)
 	}()
 	if err != nil {
 		if err != memcache.ErrCacheMiss {
@@ -110,12 +105,11 @@ func (mc *memcached) Decrement(key string, options ...int) (int, error) {
 	return int(res), nil
 }
 
-func mcError(err error) {
+func mcError(logger *log, err error) {
 	if err != nil && err != memcache.ErrCacheMiss {
 		msg := map[string]interface{}{
 			"error": err.Error(),
-			"stack": string(debug.Stack()),
 		}
-		App().Log.Error(msg, LogMemcachedError)
+		logger.errorStack(msg, LogMemcachedError)
 	}
 }
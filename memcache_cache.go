@@ -1,6 +1,7 @@
 package frame
 
 import (
+	"context"
 	"sync"
 )
 
@@ -9,12 +10,14 @@ type mcCache struct {
 	mc        *memcached
 	mcOnce    sync.Once
 	GroupName string
+	App       *app            //所属应用,为空使用默认应用
+	ctx       context.Context //报错日志使用的上下文
 }
 
 func (mcCache *mcCache) getMc() *memcached {
 	if mcCache.mc == nil {
 		mcCache.mcOnce.Do(func() {
-			mcCache.mc = &memcached{GroupName: mcCache.GroupName}
+			mcCache.mc = &memcached{GroupName: mcCache.GroupName, App: mcCache.App, ctx: mcCache.ctx}
 		})
 	}
 	return mcCache.mc
@@ -1,6 +1,7 @@
 package frame
 
 import (
+	"context"
 	"strconv"
 	"sync"
 )
@@ -10,12 +11,14 @@ type mcCounter struct {
 	mc        *memcached
 	mcOnce    sync.Once
 	GroupName string
+	App       *app            //所属应用,为空使用默认应用
+	ctx       context.Context //报错日志使用的上下文
 }
 
 func (mcCounter *mcCounter) getMc() *memcached {
 	if mcCounter.mc == nil {
 		mcCounter.mcOnce.Do(func() {
-			mcCounter.mc = &memcached{GroupName: mcCounter.GroupName}
+			mcCounter.mc = &memcached{GroupName: mcCounter.GroupName, App: mcCounter.App, ctx: mcCounter.ctx}
 		})
 	}
 	return mcCounter.mc
@@ -2,59 +2,89 @@ package frame
 
 import (
 	"github.com/bradfitz/gomemcache/memcache"
-	"runtime/debug"
-	"sync"
 	"time"
 )
 
-var mcCacheMap map[string]*memcache.Client
-var mcCacheOnce sync.Once
-var mcLock sync.Mutex
-
 type memCacheConfig struct {
-	Servers        []string `toml:"servers"`
-	ConnectTimeout int      `toml:"connect_timeout"`
-	MaxIdleConn    int      `toml:"maxIdleConn"`
+	Servers        []string `toml:"servers" validate:"required,hostport"`
+	ConnectTimeout int      `toml:"connect_timeout" validate:"min=0"`
+	MaxIdleConn    int      `toml:"maxIdleConn" validate:"min=0"`
 }
 
-func getMc(mcGroup string) *memcache.Client {
-	if mcCacheMap == nil {
-		mcCacheOnce.Do(func() {
-			mcCacheMap = make(map[string]*memcache.Client)
-		})
+func (app *app) getMc(mcGroup string) *memcache.Client {
+	res := app.resource
+	res.mcLock.RLock()
+	cache, ok := res.mcCacheMap[mcGroup]
+	res.mcLock.RUnlock()
+	if ok {
+		return cache
 	}
-	if cache, ok := mcCacheMap[mcGroup]; ok {
+	res.mcLock.Lock()
+	defer res.mcLock.Unlock()
+	if cache, ok := res.mcCacheMap[mcGroup]; ok {
 		return cache
 	}
-	mcLock.Lock()
-	defer mcLock.Unlock()
-	mcConfig := &memCacheConfig{}
-	err := App().Env(mcGroup, mcConfig)
+	//先记录文件状态再读取配置,读取期间的修改不会被漏掉
+	fileStat := app.configFileStat(mcGroup)
+	mc, err := app.newMc(mcGroup)
 	if err != nil {
 		panic(MemcachedConfigError.Error() + ":" + err.Error())
 	}
+	res.mcCacheMap[mcGroup] = mc
+	//配置文件变化后重建连接池
+	app.watchEnv(mcGroup, fileStat, func(configFile string) {
+		_ = app.ReloadMemcached(configFile)
+	})
+	return mc
+}
+
+//重新建立默认应用的memcached连接池,正在使用旧连接的请求不受影响
+func ReloadMemcached(mcGroup string) error {
+	return App().ReloadMemcached(mcGroup)
+}
+
+//重新建立memcached连接池
+func (app *app) ReloadMemcached(mcGroup string) error {
+	mc, err := app.newMc(mcGroup)
+	if err != nil {
+		msg := map[string]interface{}{
+			"group": mcGroup,
+			"error": err.Error(),
+		}
+		app.Log.errorStack(msg, LogMemcachedError)
+		return err
+	}
+	app.resource.mcLock.Lock()
+	app.resource.mcCacheMap[mcGroup] = mc
+	app.resource.mcLock.Unlock()
+	return nil
+}
+
+func (app *app) newMc(mcGroup string) (*memcache.Client, error) {
+	mcConfig := &memCacheConfig{}
+	err := app.Env(mcGroup, mcConfig)
+	if err != nil {
+		return nil, err
+	}
 	serverList := mcConfig.Servers
 	mc := memcache.New(serverList...)
 	if mc == nil {
 		msg := map[string]interface{}{
 			"error": "memcached New failed",
-			"stack": string(debug.Stack()),
 		}
-		App().Log.Error(msg, LogMemcachedError)
+		app.Log.errorStack(msg, LogMemcachedError)
 		panic("memcached New failed")
 	}
 	mc.Timeout = time.Duration(mcConfig.ConnectTimeout) * time.Second
 	mc.MaxIdleConns = mcConfig.MaxIdleConn
-	mcCacheMap[mcGroup] = mc
-	return mc
+	return mc, nil
 }
 
 //关闭连接池中所有闲置连接
-func closeMemcachedPool() {
-	if mcCacheMap == nil {
-		return
-	}
-	for _, v := range mcCacheMap {
+func (app *app) closeMemcachedPool() {
+	app.resource.mcLock.RLock()
+	defer app.resource.mcLock.RUnlock()
+	for _, v := range app.resource.mcCacheMap {
 		v.MaxIdleConns = 0
 	}
-}
\ No newline at end of file
+}
@@ -1,10 +1,15 @@
 package frame
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
 	_ "github.com/go-sql-driver/mysql"
 	"math/rand"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -21,6 +26,8 @@ const (
 	SqlTypeInsertBatch
 	SqlTypeUpdateBatch
 	SqlTypeReplaceBatch
+	SqlTypeUpsert
+	SqlTypeUpsertBatch
 )
 
 const RwTypeMaster = "m"
@@ -29,6 +36,7 @@ const RwTypeSlave = "s"
 type Mysql struct {
 	stmt          *sql.Stmt
 	commitCon     *sql.Tx
+	transGroup    *dbGroup //事务所在的连接池,事务结束前不会被关闭
 	inTrans       bool
 	transDepth    int
 	lastErrorCode int
@@ -65,6 +73,8 @@ type Mysql struct {
 	valuesSqlArr []string
 	//update
 	updateSql string
+	//upsert,upsert batch
+	duplicateSql string
 	//update batch
 	updateSqlArr         []string
 	updateWhereSqlArr    []string
@@ -76,6 +86,8 @@ type Mysql struct {
 	//update batch
 	updateParamsArr [][]interface{}
 
+	//拼接SQL时的错误,Exec 时返回失败
+	buildError error
 	//上一次的SQL语句(参数)
 	lastPreSql    string
 	lastPreSqlArr []string
@@ -98,15 +110,61 @@ type Mysql struct {
 	//begin exec time 开始执行时间
 	BeginTime int
 
-	DbGroup *dbGroup //数据库连接池
+	DbGroup     *dbGroup //数据库连接池
+	dbGroupName string   //数据库配置 如:db/main
+	App         *app     //所属应用,为空使用默认应用
+	ctx         context.Context
+	cancel      context.CancelFunc //当前SQL的超时取消,结果读取完后释放
 }
 
 //没有使用单例 是因为协程间会共用 导致问题
 //目前又无法获取协程id 无法做到同一协程间单例
 func GetMysql(dbGroup string) *Mysql {
-	DbGroup := openDB(dbGroup)
+	return newMysql(App(), dbGroup)
+}
+
+//设置上下文,报错和慢查询日志带上上下文中的字段
+//上下文传入 database/sql,上下文取消(如请求中断)或者超时后SQL随之取消,事务中同样有效
+//开启事务时的上下文取消后事务会自动回滚
+func (mysql *Mysql) WithContext(ctx context.Context) Db {
+	mysql.ctx = ctx
+	return mysql
+}
+
+//执行SQL使用的上下文,带上配置的默认超时时间
+//*gin.Context 使用请求的上下文,请求中断时可以取消
+func (mysql *Mysql) queryContext() context.Context {
+	ctx := mysql.baseContext()
+	if mysql.DbGroup.QueryTimeout > 0 {
+		ctx, mysql.cancel = context.WithTimeout(ctx, mysql.DbGroup.QueryTimeout)
+	}
+	return ctx
+}
+
+func (mysql *Mysql) baseContext() context.Context {
+	if mysql.ctx == nil {
+		return context.Background()
+	}
+	if c, ok := mysql.ctx.(*gin.Context); ok {
+		if c.Request == nil {
+			return context.Background()
+		}
+		return c.Request.Context()
+	}
+	return mysql.ctx
+}
+
+//日志,有上下文时使用上下文日志
+func (mysql *Mysql) Log() *log {
+	return contextLog(mysql.App, mysql.ctx)
+}
+
+func newMysql(app *app, dbGroup string) *Mysql {
+	DbGroup := app.openDB(dbGroup)
 	return &Mysql{
+		App:                  app,
 		DbGroup:              DbGroup,
+		dbGroupName:          dbGroup,
 		stmt:                 nil,
 		commitCon:            nil,
 		inTrans:              false,
@@ -154,6 +212,7 @@ func (mysql *Mysql) resetBefore() {
 	mysql.affectedRows = 0
 	mysql.affectedRowsOnce = 0
 	mysql.lastInsertId = 0
+	mysql.buildError = nil
 }
 
 func (mysql *Mysql) resetAfter() {
@@ -176,6 +235,7 @@ func (mysql *Mysql) resetAfter() {
 	mysql.valuesSql = ""
 	mysql.valuesSqlArr = make([]string, 0)
 	mysql.updateSql = ""
+	mysql.duplicateSql = ""
 	mysql.updateSqlArr = make([]string, 0)
 	mysql.updateWhereSqlArr = make([]string, 0)
 	mysql.updateWhereParamsArr = make([]interface{}, 0)
@@ -188,6 +248,7 @@ func (mysql *Mysql) resetAfter() {
 	mysql.lastParams = make([]interface{}, 0)
 	mysql.handleTemp = ""
 	mysql.BeginTime = 0
+	mysql.buildError = nil
 }
 
 func (mysql *Mysql) Reset() {
@@ -380,6 +441,76 @@ func (mysql *Mysql) Update(table string, info map[string]interface{}) Db {
 	return mysql
 }
 
+//按key批量更新不同的值,每onceMaxCount行一条SQL:
+//UPDATE `table` SET `name` = CASE `id` WHEN ? THEN ? ... ELSE `name` END,... WHERE `id` IN (...)
+//没有某一列的行该列保持不变,可以再加 Where 条件,Exec 返回总影响行数
+//有行缺少key时不执行,Exec 返回失败
+//onceMaxCount 没有传或者小于等于0时为100
+func (mysql *Mysql) UpdateBatch(table string, data []map[string]interface{}, key string, onceMaxCounts ...int) Db {
+	onceMaxCount := 100
+	if len(onceMaxCounts) > 0 && onceMaxCounts[0] > 0 {
+		onceMaxCount = onceMaxCounts[0]
+	}
+	mysql.resetBefore()
+	mysql.sqlType = SqlTypeUpdateBatch
+	if len(data) == 0 {
+		return mysql
+	}
+	mysql.tableSql = mysql.escapeTable(table)
+	//所有行的列,按列名排序保证SQL稳定
+	columnMap := make(map[string]bool)
+	for _, item := range data {
+		if _, ok := item[key]; !ok {
+			mysql.buildError = errors.New(DbBatchKeyError.Error() + ":" + key)
+			return mysql
+		}
+		for k := range item {
+			if k != key {
+				columnMap[k] = true
+			}
+		}
+	}
+	columns := make([]string, 0, len(columnMap))
+	for k := range columnMap {
+		columns = append(columns, k)
+	}
+	sort.Strings(columns)
+	keyField := mysql.escapeField(key)
+	updateDataOnceArr := arrayChunk(data, onceMaxCount)
+	for _, updateDataOnce := range updateDataOnceArr {
+		updateSegment := make([]string, 0, len(columns))
+		params := make([]interface{}, 0)
+		for _, column := range columns {
+			field := mysql.escapeField(column)
+			whenSql := ""
+			for _, item := range updateDataOnce {
+				if v, ok := item[column]; ok {
+					whenSql += " WHEN ? THEN ?"
+					params = append(params, item[key], v)
+				}
+			}
+			//这一批都没有这一列
+			if whenSql == "" {
+				continue
+			}
+			updateSegment = append(updateSegment, field+" = CASE "+keyField+whenSql+" ELSE "+field+" END")
+		}
+		//这一批只有key,不需要更新
+		if len(updateSegment) == 0 {
+			continue
+		}
+		keys := make([]interface{}, 0, len(updateDataOnce))
+		for _, item := range updateDataOnce {
+			keys = append(keys, item[key])
+		}
+		mysql.updateSqlArr = append(mysql.updateSqlArr, strings.Join(updateSegment, ","))
+		mysql.updateParamsArr = append(mysql.updateParamsArr, params)
+		mysql.updateWhereSqlArr = append(mysql.updateWhereSqlArr, "WHERE "+keyField+" IN (?)")
+		mysql.updateWhereParamsArr = append(mysql.updateWhereParamsArr, keys)
+	}
+	return mysql
+}
+
 func (mysql *Mysql) Replace(table string, info map[string]interface{}) Db {
 	mysql.resetBefore()
 	mysql.sqlType = SqlTypeReplace
@@ -431,6 +562,58 @@ func (mysql *Mysql) ReplaceBatch(table string, data []map[string]interface{}, on
 	return mysql
 }
 
+//原样写入SQL的表达式,不做转义,不能包含?,不要拼接外部输入
+type Raw string
+
+//插入,唯一键冲突时更新:INSERT INTO ... ON DUPLICATE KEY UPDATE ...
+//updateColumns 为列名(string)时转义后更新为插入的值 `col`=VALUES(`col`)
+//表达式需要使用 Raw,如 frame.Raw("count = count + VALUES(count)")
+//updateColumns 按顺序生成,不能为空,为空、类型错误或者表达式包含?时 Exec 返回失败
+//Exec 返回 MySQL 的 insert_id:插入时为新行的自增id,更新时一般为0
+//更新时需要返回已有行的id可以加上 frame.Raw("id = LAST_INSERT_ID(id)")
+//插入还是更新通过 AffectedRows 区分,和MySQL一致:插入为1,更新为2,值没有变化为0
+func (mysql *Mysql) Upsert(table string, info map[string]interface{}, updateColumns []interface{}) Db {
+	mysql.Insert(table, info)
+	mysql.sqlType = SqlTypeUpsert
+	mysql.duplicateSql = mysql.getDuplicateSql(updateColumns)
+	return mysql
+}
+
+//批量插入,唯一键冲突时更新,分批方式和 InsertBatch 相同,updateColumns 同 Upsert
+//Exec 返回所有批次的总影响行数,每行插入计1,更新计2
+func (mysql *Mysql) UpsertBatch(table string, data []map[string]interface{}, updateColumns []interface{}, onceMaxCounts ...int) Db {
+	mysql.InsertBatch(table, data, onceMaxCounts...)
+	mysql.sqlType = SqlTypeUpsertBatch
+	mysql.duplicateSql = mysql.getDuplicateSql(updateColumns)
+	return mysql
+}
+
+func (mysql *Mysql) getDuplicateSql(updateColumns []interface{}) string {
+	if len(updateColumns) == 0 {
+		mysql.buildError = errors.New(DbUpsertColumnsError.Error() + ":不能为空")
+		return ""
+	}
+	updateSegment := make([]string, 0, len(updateColumns))
+	for _, column := range updateColumns {
+		switch v := column.(type) {
+		case Raw:
+			//表达式中的?会被当作参数占位符
+			if strings.Contains(string(v), "?") {
+				mysql.buildError = errors.New(DbUpsertColumnsError.Error() + ":表达式不能包含? " + string(v))
+				return ""
+			}
+			updateSegment = append(updateSegment, string(v))
+		case string:
+			field := mysql.escapeField(v)
+			updateSegment = append(updateSegment, field+"=VALUES("+field+")")
+		default:
+			mysql.buildError = errors.New(DbUpsertColumnsError.Error() + ":只能是列名或者 Raw,当前类型 " + fmt.Sprintf("%T", column))
+			return ""
+		}
+	}
+	return "ON DUPLICATE KEY UPDATE " + strings.Join(updateSegment, ",")
+}
+
 func (mysql *Mysql) Delete(table string) Db {
 	mysql.resetBefore()
 	mysql.sqlType = SqlTypeDelete
@@ -989,6 +1172,13 @@ func (mysql *Mysql) getPrepareSql() string {
 			mysql.lastPreSqlArr = append(mysql.lastPreSqlArr, "REPLACE INTO "+mysql.tableSql+" ("+mysql.fieldSql+") VALUES "+valueSql)
 		}
 		mysql.lastPreSql = ""
+	case SqlTypeUpsert:
+		mysql.lastPreSql = "INSERT INTO " + mysql.tableSql + " (" + mysql.fieldSql + ") VALUES " + mysql.valuesSql + " " + mysql.duplicateSql
+	case SqlTypeUpsertBatch:
+		for _, valueSql := range mysql.valuesSqlArr {
+			mysql.lastPreSqlArr = append(mysql.lastPreSqlArr, "INSERT INTO "+mysql.tableSql+" ("+mysql.fieldSql+") VALUES "+valueSql+" "+mysql.duplicateSql)
+		}
+		mysql.lastPreSql = ""
 	case SqlTypeDelete:
 		sqlStr := "DELETE FROM " + mysql.tableSql
 		if mysql.whereSql != "" {
@@ -1026,20 +1216,27 @@ func (mysql *Mysql) getParams() []interface{} {
 				mysql.lastParams = append(mysql.lastParams, v)
 			}
 		case SqlTypeUpdateBatch:
+			//每条SQL一组参数
 			paramsArr := make([]interface{}, 0)
 			for key, updateParams := range mysql.updateParamsArr {
-				paramsArr = append(paramsArr, updateParams...)
-				paramsArr = append(paramsArr, mysql.updateWhereParamsArr[key])
-				paramsArr = append(paramsArr, mysql.whereParams...)
+				params := make([]interface{}, 0)
+				params = append(params, updateParams...)
+				params = append(params, mysql.updateWhereParamsArr[key])
+				params = append(params, mysql.whereParams...)
 				for _, v := range mysql.getLimitParams() {
-					paramsArr = append(paramsArr, v)
+					params = append(params, v)
 				}
+				paramsArr = append(paramsArr, params)
 			}
 			mysql.lastParams = paramsArr
 		case SqlTypeReplace:
 			mysql.lastParams = mysql.params
 		case SqlTypeReplaceBatch:
 			mysql.lastParams = mysql.paramsArr
+		case SqlTypeUpsert:
+			mysql.lastParams = mysql.params
+		case SqlTypeUpsertBatch:
+			mysql.lastParams = mysql.paramsArr
 		case SqlTypeDelete:
 			mysql.lastParams = mysql.whereParams
 			for _, v := range mysql.getLimitParams() {
@@ -1052,8 +1249,16 @@ func (mysql *Mysql) getParams() []interface{} {
 	return mysql.lastParams
 }
 
+//不在事务中时重新获取连接池,配置变更重建连接池后可以立即使用新的连接池
+func (mysql *Mysql) refreshDbGroup() {
+	if mysql.commitCon == nil && mysql.dbGroupName != "" {
+		mysql.DbGroup = getApp(mysql.App).openDB(mysql.dbGroupName)
+	}
+}
+
 func (mysql *Mysql) getConn(rwType string) *sql.DB {
-	if mysql.inTrans == true || mysql.forceMaster == true || rwType == RwTypeMaster {
+	mysql.refreshDbGroup()
+	if mysql.inTrans == true || mysql.forceMaster == true || rwType == RwTypeMaster || len(mysql.DbGroup.Slaves) == 0 {
 		return mysql.DbGroup.Master
 	}
 	rand.Seed(time.Now().UnixNano())
@@ -1086,16 +1291,21 @@ func (mysql *Mysql) pdoExecute(preSql string, params []interface{}, rwType strin
 	mysql.lastErrorCode = 0
 	var stmt *sql.Stmt
 	var err error
+	//批量执行时释放上一条的stmt和超时
+	stmtClose(mysql)
 	mysql.BeginTime = int(time.Now().Unix())
 	//前置操作
 	if mysqlHandle != nil && mysqlHandle.beforeExecute != nil {
 		mysqlHandle.beforeExecute(mysql)
 	}
+	var ctx context.Context
 	if mysql.commitCon != nil {
-		stmt, err = mysql.commitCon.Prepare(actualPreSql)
+		ctx = mysql.queryContext()
+		stmt, err = mysql.commitCon.PrepareContext(ctx, actualPreSql)
 	} else {
 		conn := mysql.getConn(rwType)
-		stmt, err = conn.Prepare(actualPreSql)
+		ctx = mysql.queryContext()
+		stmt, err = conn.PrepareContext(ctx, actualPreSql)
 	}
 	if err != nil {
 		//报错
@@ -1110,10 +1320,10 @@ func (mysql *Mysql) pdoExecute(preSql string, params []interface{}, rwType strin
 		return nil
 	}
 	if mysql.handleTemp == "fetch" {
-		row := stmt.QueryRow(actualParams...)
+		row := stmt.QueryRowContext(ctx, actualParams...)
 		return row
 	} else if mysql.handleTemp == "fetchAll" {
-		rows, err := stmt.Query(actualParams...)
+		rows, err := stmt.QueryContext(ctx, actualParams...)
 		if err != nil {
 			//报错
 			if mysqlHandle != nil && mysqlHandle.errExecute != nil {
@@ -1123,7 +1333,7 @@ func (mysql *Mysql) pdoExecute(preSql string, params []interface{}, rwType strin
 		}
 		return rows
 	} else {
-		result, err := mysql.stmt.Exec(actualParams...)
+		result, err := mysql.stmt.ExecContext(ctx, actualParams...)
 		if err != nil {
 			//报错
 			if mysqlHandle != nil && mysqlHandle.errExecute != nil {
@@ -1143,9 +1353,13 @@ func (mysql *Mysql) pdoExecute(preSql string, params []interface{}, rwType strin
 	}
 }
 
+//构建失败(如 UpdateBatch 缺少key)时返回nil
 func (mysql *Mysql) GetSql() interface{} {
 	defer mysql.resetAfter()
-	if mysql.sqlType == SqlTypeInsertBatch || mysql.sqlType == SqlTypeUpdateBatch || mysql.sqlType == SqlTypeReplaceBatch {
+	if mysql.buildError != nil {
+		return nil
+	}
+	if mysql.sqlType == SqlTypeInsertBatch || mysql.sqlType == SqlTypeUpdateBatch || mysql.sqlType == SqlTypeReplaceBatch || mysql.sqlType == SqlTypeUpsertBatch {
 		mysql.getPrepareSql()
 		preSqlArr := mysql.lastPreSqlArr
 		paramsArr := mysql.getParams()
@@ -1185,13 +1399,16 @@ func (mysql *Mysql) Exec() (int, bool) {
 	defer stmtClose(mysql)
 	//执行"写"的SQL语句
 	rwType := RwTypeMaster
+	if mysql.checkBuildError() != nil {
+		return 0, false
+	}
 	preSqlData := mysql.getPrepareSql()
 	paramsData := mysql.getParams()
 	mysql.affectedRows = 0
-	if mysql.sqlType == SqlTypeInsertBatch || mysql.sqlType == SqlTypeUpdateBatch || mysql.sqlType == SqlTypeReplaceBatch {
+	if mysql.sqlType == SqlTypeInsertBatch || mysql.sqlType == SqlTypeUpdateBatch || mysql.sqlType == SqlTypeReplaceBatch || mysql.sqlType == SqlTypeUpsertBatch {
 		for key, preSql := range mysql.lastPreSqlArr {
+			//失败时返回已经执行的批次的总影响行数
 			if res := mysql.pdoExecute(preSql, paramsData[key].([]interface{}), rwType); res == nil {
-				mysql.affectedRows = mysql.affectedRowsOnce
 				mysql.resetAfter()
 				return mysql.affectedRows, false
 			}
@@ -1208,6 +1425,10 @@ func (mysql *Mysql) Exec() (int, bool) {
 		if res == nil {
 			return 0, false
 		}
+		//upsert 返回 insert_id,插入还是更新通过 AffectedRows 区分
+		if mysql.sqlType == SqlTypeUpsert {
+			return mysql.lastInsertId, true
+		}
 		//添加操作返回自增id
 		if mysql.sqlType == SqlTypeInsert {
 			if mysql.affectedRows == 1 {
@@ -1218,8 +1439,24 @@ func (mysql *Mysql) Exec() (int, bool) {
 	}
 }
 
+//构建SQL时的错误,有错误时调用错误回调并重置,不执行SQL
+func (mysql *Mysql) checkBuildError() error {
+	err := mysql.buildError
+	if err == nil {
+		return nil
+	}
+	if mysqlHandle != nil && mysqlHandle.errExecute != nil {
+		mysqlHandle.errExecute(mysql, err)
+	}
+	mysql.resetAfter()
+	return err
+}
+
 func (mysql *Mysql) Fetch(res interface{}) (interface{}, error) {
 	defer stmtClose(mysql)
+	if err := mysql.checkBuildError(); err != nil {
+		return nil, err
+	}
 	mysql.handleTemp = "fetch"
 	execResult := mysql.pdoExecute(mysql.getPrepareSql(), mysql.getParams(), RwTypeSlave)
 	if execResult == nil {
@@ -1253,6 +1490,9 @@ func (mysql *Mysql) Fetch(res interface{}) (interface{}, error) {
 
 func (mysql *Mysql) FetchAll(res interface{}) ([]interface{}, error) {
 	defer stmtClose(mysql)
+	if err := mysql.checkBuildError(); err != nil {
+		return nil, err
+	}
 	defer mysql.resetAfter()
 	mysql.handleTemp = "fetchAll"
 	execResult := mysql.pdoExecute(mysql.getPrepareSql(), mysql.getParams(), RwTypeSlave)
@@ -1311,8 +1551,12 @@ func (mysql *Mysql) BeginTrans() bool {
 		return true
 	}
 	mysql.lastErrorCode = 0
-	tx, err := mysql.DbGroup.Master.Begin()
+	mysql.refreshDbGroup()
+	mysql.transGroup = mysql.DbGroup
+	mysql.transGroup.beginTrans()
+	tx, err := mysql.DbGroup.Master.BeginTx(mysql.baseContext(), nil)
 	if err != nil {
+		mysql.endTrans()
 		if mysqlHandle != nil && mysqlHandle.errExecute != nil {
 			mysqlHandle.errExecute(mysql, err)
 		}
@@ -1322,6 +1566,14 @@ func (mysql *Mysql) BeginTrans() bool {
 	return true
 }
 
+//提交或者回滚后事务已经结束,不再占用连接池
+func (mysql *Mysql) endTrans() {
+	if mysql.transGroup != nil {
+		mysql.transGroup.endTrans()
+		mysql.transGroup = nil
+	}
+}
+
 func (mysql *Mysql) CommitTrans() bool {
 	if !mysql.inTrans {
 		return true
@@ -1335,6 +1587,7 @@ func (mysql *Mysql) CommitTrans() bool {
 		return true
 	}
 	err := mysql.commitCon.Commit()
+	mysql.endTrans()
 	if err != nil {
 		if mysqlHandle != nil && mysqlHandle.errExecute != nil {
 			mysqlHandle.errExecute(mysql, err)
@@ -1360,6 +1613,7 @@ func (mysql *Mysql) RollbackTrans() bool {
 	}
 	mysql.lastErrorCode = 0
 	err := mysql.commitCon.Rollback()
+	mysql.endTrans()
 	if err != nil {
 		if mysqlHandle != nil && mysqlHandle.errExecute != nil {
 			mysqlHandle.errExecute(mysql, err)
@@ -1412,6 +1666,10 @@ func stmtClose(mysql *Mysql) {
 		_ = mysql.stmt.Close()
 		mysql.stmt = nil
 	}
+	if mysql.cancel != nil {
+		mysql.cancel()
+		mysql.cancel = nil
+	}
 }
 
 func addSlashesParam(val interface{}) string {
@@ -1434,11 +1692,11 @@ func addSlashesParam(val interface{}) string {
 			case "string":
 				strIn += "'" + addSlashes(item.(string)) + "',"
 			case "float64":
-				strIn += strconv.FormatFloat(item.(float64), 'f', 6, 64)
+				strIn += strconv.FormatFloat(item.(float64), 'f', 6, 64) + ","
 			case "float32":
-				strIn += strconv.FormatFloat(float64(item.(float32)), 'f', 6, 64)
+				strIn += strconv.FormatFloat(float64(item.(float32)), 'f', 6, 64) + ","
 			default:
-				strIn += strconv.Itoa(item.(int))
+				strIn += addSlashesParam(item) + ","
 			}
 		}
 		str += strings.TrimRight(strIn, ",")
@@ -1446,8 +1704,10 @@ func addSlashesParam(val interface{}) string {
 		str += strconv.FormatFloat(val.(float64), 'f', 6, 64)
 	case "float32":
 		str += strconv.FormatFloat(float64(val.(float32)), 'f', 6, 32)
-	case "int", "int8", "int16", "int64", "int32", "uint", "uint8", "uint16", "uint32", "uint64":
-		str += strconv.Itoa(val.(int))
+	case "int", "int8", "int16", "int64", "int32":
+		str += strconv.FormatInt(reflect.ValueOf(val).Int(), 10)
+	case "uint", "uint8", "uint16", "uint32", "uint64":
+		str += strconv.FormatUint(reflect.ValueOf(val).Uint(), 10)
 	default:
 		str += val.(string)
 	}
@@ -0,0 +1,171 @@
+package frame
+
+import (
+	"reflect"
+	"testing"
+)
+
+func newTestMysql(t *testing.T) (*Mysql, func()) {
+	testApp, clean := newTestApp(t, map[string]string{
+		"develop/db/main.toml": `
+type = "mysql"
+[master]
+host = "127.0.0.1"
+port = 3306
+username = "root"
+dbname = "test"
+`,
+	})
+	return testApp.GetMysql("db/main"), clean
+}
+
+func TestMysqlUpdateBatchSql(t *testing.T) {
+	mysql, clean := newTestMysql(t)
+	defer clean()
+	tests := []struct {
+		name  string
+		data  []map[string]interface{}
+		key   string
+		sizes []int
+		want  interface{}
+	}{
+		{
+			name: "columns sorted and missing columns kept",
+			data: []map[string]interface{}{
+				{"id": 1, "name": "a", "age": 10},
+				{"id": int64(2), "name": "b'c"},
+			},
+			key: "id",
+			want: []string{
+				"UPDATE `user` SET `age` = CASE `id` WHEN 1 THEN 10 ELSE `age` END,`name` = CASE `id` WHEN 1 THEN 'a' WHEN 2 THEN 'b\\'c' ELSE `name` END WHERE `id` IN (1,2)",
+			},
+		},
+		{
+			name: "chunks",
+			data: []map[string]interface{}{
+				{"id": uint32(1), "name": "a"},
+				{"id": uint32(2), "name": "b"},
+				{"id": uint32(3), "name": "c"},
+			},
+			key:   "id",
+			sizes: []int{2},
+			want: []string{
+				"UPDATE `user` SET `name` = CASE `id` WHEN 1 THEN 'a' WHEN 2 THEN 'b' ELSE `name` END WHERE `id` IN (1,2)",
+				"UPDATE `user` SET `name` = CASE `id` WHEN 3 THEN 'c' ELSE `name` END WHERE `id` IN (3)",
+			},
+		},
+		{
+			name: "key only chunk skipped",
+			data: []map[string]interface{}{
+				{"id": 1, "name": "a"},
+				{"id": 2},
+			},
+			key:   "id",
+			sizes: []int{1},
+			want: []string{
+				"UPDATE `user` SET `name` = CASE `id` WHEN 1 THEN 'a' ELSE `name` END WHERE `id` IN (1)",
+			},
+		},
+		{
+			name:  "non positive size uses default",
+			data:  []map[string]interface{}{{"id": 1, "name": "a"}, {"id": 2, "name": "b"}},
+			key:   "id",
+			sizes: []int{0},
+			want: []string{
+				"UPDATE `user` SET `name` = CASE `id` WHEN 1 THEN 'a' WHEN 2 THEN 'b' ELSE `name` END WHERE `id` IN (1,2)",
+			},
+		},
+		{
+			name: "missing key",
+			data: []map[string]interface{}{{"id": 1, "name": "a"}, {"name": "b"}},
+			key:  "id",
+			want: nil,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := mysql.UpdateBatch("user", test.data, test.key, test.sizes...).GetSql()
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("SQL %#v,需要 %#v", got, test.want)
+			}
+		})
+	}
+}
+
+//构建失败时不执行,所有执行方法都返回失败
+func TestMysqlBuildError(t *testing.T) {
+	mysql, clean := newTestMysql(t)
+	defer clean()
+	missingKey := []map[string]interface{}{{"name": "a"}}
+	if _, ok := mysql.UpdateBatch("user", missingKey, "id").Exec(); ok {
+		t.Fatal("Exec 需要返回失败")
+	}
+	if _, err := mysql.UpdateBatch("user", missingKey, "id").Fetch(&struct{ Id int }{}); err == nil {
+		t.Fatal("Fetch 需要返回错误")
+	}
+	if _, err := mysql.UpdateBatch("user", missingKey, "id").FetchAll(&struct{ Id int }{}); err == nil {
+		t.Fatal("FetchAll 需要返回错误")
+	}
+	//下一条语句不受影响
+	want := "SELECT * FROM `user` WHERE `id` = 1"
+	if got := mysql.Select().From("user").Where("id", 1).GetSql(); got != want {
+		t.Fatalf("SQL %#v,需要 %#v", got, want)
+	}
+}
+
+func TestMysqlUpsertSql(t *testing.T) {
+	mysql, clean := newTestMysql(t)
+	defer clean()
+	insertSql := "INSERT INTO `stat` (`day`) VALUES ('d1') "
+	tests := []struct {
+		name    string
+		columns []interface{}
+		want    interface{}
+	}{
+		{
+			name:    "columns in order",
+			columns: []interface{}{"name", "count"},
+			want:    insertSql + "ON DUPLICATE KEY UPDATE `name`=VALUES(`name`),`count`=VALUES(`count`)",
+		},
+		{
+			name:    "column escaped",
+			columns: []interface{}{"count = 0, `x`"},
+			want:    insertSql + "ON DUPLICATE KEY UPDATE `count = 0, x`=VALUES(`count = 0, x`)",
+		},
+		{
+			name:    "raw expression",
+			columns: []interface{}{Raw("count = count + VALUES(count)"), "name"},
+			want:    insertSql + "ON DUPLICATE KEY UPDATE count = count + VALUES(count),`name`=VALUES(`name`)",
+		},
+		{
+			name:    "raw with placeholder",
+			columns: []interface{}{Raw("count = ?")},
+			want:    nil,
+		},
+		{
+			name:    "wrong type",
+			columns: []interface{}{1},
+			want:    nil,
+		},
+		{
+			name: "empty",
+			want: nil,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := mysql.Upsert("stat", map[string]interface{}{"day": "d1"}, test.columns).GetSql()
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("SQL %#v,需要 %#v", got, test.want)
+			}
+		})
+	}
+	got := mysql.UpsertBatch("stat", []map[string]interface{}{{"day": "d1"}, {"day": "d2"}}, []interface{}{Raw("count = count + 1")}, 1).GetSql()
+	want := []string{
+		"INSERT INTO `stat` (`day`) VALUES ('d1') ON DUPLICATE KEY UPDATE count = count + 1",
+		"INSERT INTO `stat` (`day`) VALUES ('d2') ON DUPLICATE KEY UPDATE count = count + 1",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("SQL %#v,需要 %#v", got, want)
+	}
+}
@@ -1,14 +1,11 @@
 package frame
 
-import (
-	"runtime/debug"
-)
-
 // 队列结构体
 type QueueInstance struct {
 	Key       string   //队列key值
 	Type      string   //队列类型
 	GroupName string
+	App       *app //所属应用,为空使用默认应用
 	server    Queue
 }
 
@@ -26,13 +23,12 @@ func (queue *QueueInstance) getServer() Queue {
 	if queue.server == nil {
 		//根据类型获取队列
 		if queue.Type == QueueTypeRedis {
-			queue.server = &redisQueue{GroupName: queue.GroupName}
+			queue.server = &redisQueue{GroupName: queue.GroupName, App: queue.App}
 		} else {
 			msg := map[string]interface{}{
 				"error": "获取队列出错",
-				"stack": string(debug.Stack()),
 			}
-			App().Log.Error(msg, LogQueueError)
+			getApp(queue.App).Log.errorStack(msg, LogQueueError)
 			panic("获取队列出错")
 		}
 	}
@@ -1,19 +1,23 @@
 package frame
 
 import (
+	"context"
 	"github.com/gomodule/redigo/redis"
 	"math/rand"
-	"runtime/debug"
 	"strings"
-	"sync"
 	"time"
 )
 
 // redis结构体
 type Redis struct {
-	redisPool     *redisGroup
-	redisPoolOnce sync.Once
-	GroupName     string
+	GroupName string
+	App       *app            //所属应用,为空使用默认应用
+	ctx       context.Context //报错日志使用的上下文
+}
+
+//返回使用上下文的副本,报错日志带上上下文中的字段
+func (redisObj *Redis) WithContext(ctx context.Context) *Redis {
+	return &Redis{GroupName: redisObj.GroupName, App: redisObj.App, ctx: ctx}
 }
 
 var redisReadMethod = []string{
@@ -25,22 +29,22 @@ var redisReadMethod = []string{
 }
 
 func (redisObj *Redis) getMaster() *redis.Pool {
-	redisObj.initPool()
-	return redisObj.redisPool.Master
+	return redisObj.initPool().Master
 }
 
 func (redisObj *Redis) getSlave() *redis.Pool {
-	redisObj.initPool()
+	redisPool := redisObj.initPool()
+	//没有配置从库时使用主库
+	if len(redisPool.Slaves) == 0 {
+		return redisPool.Master
+	}
 	rand.Seed(time.Now().UnixNano())
-	return redisObj.redisPool.Slaves[rand.Intn(len(redisObj.redisPool.Slaves))]
+	return redisPool.Slaves[rand.Intn(len(redisPool.Slaves))]
 }
 
-func (redisObj *Redis) initPool() {
-	if redisObj.redisPool == nil {
-		redisObj.redisPoolOnce.Do(func() {
-			redisObj.redisPool = GetRedis(redisObj.GroupName)
-		})
-	}
+//每次都从连接池缓存中获取,配置变更重建连接池后可以立即使用新的连接池
+func (redisObj *Redis) initPool() *redisGroup {
+	return getApp(redisObj.App).GetRedis(redisObj.GroupName)
 }
 
 func (redisObj *Redis) getPool(method string) *redis.Pool {
@@ -57,7 +61,7 @@ func (redisObj *Redis) Get(key string) (string, error) {
 	defer c.Close()
 	r, err := redis.String(c.Do("Get", key))
 	defer func() {
-		redisError(err)
+		redisError(contextLog(redisObj.App, redisObj.ctx), err)
 	}()
 	if err != nil {
 		if err == redis.ErrNil {
@@ -78,7 +82,7 @@ func (redisObj *Redis) Set(key string, value string, ttl ...int) bool {
 	defer c.Close()
 	var err error
 	defer func() {
-		redisError(err)
+		redisError(contextLog(redisObj.App, redisObj.ctx), err)
 	}()
 	if ttlTime > -1 {
 		_, err = c.Do("SET", key, value, "EX", ttlTime)
@@ -104,7 +108,7 @@ func (redisObj *Redis) SetNx(key string, value string, ttl ...int) bool {
 	defer c.Close()
 	var err error
 	defer func() {
-		redisError(err)
+		redisError(contextLog(redisObj.App, redisObj.ctx), err)
 	}()
 	var res interface{}
 	if ttlTime > -1 {
@@ -151,7 +155,7 @@ func (redisObj *Redis) Delete(key string) bool {
 	defer c.Close()
 	_, err := c.Do("Del", key)
 	defer func() {
-		redisError(err)
+		redisError(contextLog(redisObj.App, redisObj.ctx), err)
 	}()
 	if err != nil {
 		return false
@@ -168,7 +172,7 @@ func (redisObj *Redis) Increment(key string, options ...int) (int, error) {
 	defer c.Close()
 	res, err := c.Do("INCRBY", key, step)
 	defer func() {
-		redisError(err)
+		redisError(contextLog(redisObj.App, redisObj.ctx), err)
 	}()
 	if err != nil {
 		return 0, err
@@ -185,7 +189,7 @@ func (redisObj *Redis) Decrement(key string, options ...int) (int, error) {
 	defer c.Close()
 	res, err := c.Do("DECRBY", key, step)
 	defer func() {
-		redisError(err)
+		redisError(contextLog(redisObj.App, redisObj.ctx), err)
 	}()
 	if err != nil {
 		return 0, err
@@ -198,7 +202,7 @@ func (redisObj *Redis) LLen(key string) (int, error) {
 	defer c.Close()
 	r, err := c.Do("llen", key)
 	defer func() {
-		redisError(err)
+		redisError(contextLog(redisObj.App, redisObj.ctx), err)
 	}()
 	if err != nil {
 		return 0, err
@@ -211,7 +215,7 @@ func (redisObj *Redis) RPush(key string, value string) bool {
 	defer c.Close()
 	_, err := c.Do("Rpush", key, value)
 	defer func() {
-		redisError(err)
+		redisError(contextLog(redisObj.App, redisObj.ctx), err)
 	}()
 	if err != nil {
 		return false
@@ -224,7 +228,7 @@ func (redisObj *Redis) LPop(key string) (string, error) {
 	defer c.Close()
 	r, err := redis.String(c.Do("Lpop", key))
 	defer func() {
-		redisError(err)
+		redisError(contextLog(redisObj.App, redisObj.ctx), err)
 	}()
 	if err != nil {
 		if err == redis.ErrNil {
@@ -235,12 +239,11 @@ func (redisObj *Redis) LPop(key string) (string, error) {
 	return r, nil
 }
 
-func redisError(err error) {
+func redisError(logger *log, err error) {
 	if err != nil {
 		msg := map[string]interface{}{
 			"error": err.Error(),
-			"stack": string(debug.Stack()),
 		}
-		App().Log.Error(msg, LogRedisError)
+		logger.errorStack(msg, LogRedisError)
 	}
 }
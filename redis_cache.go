@@ -1,6 +1,7 @@
 package frame
 
 import (
+	"context"
 	"sync"
 )
 
@@ -9,12 +10,14 @@ type redisCache struct {
 	redis     *Redis
 	redisOnce sync.Once
 	GroupName string
+	App       *app            //所属应用,为空使用默认应用
+	ctx       context.Context //报错日志使用的上下文
 }
 
 func (redisCache *redisCache) getRedis() *Redis {
 	if redisCache.redis == nil {
 		redisCache.redisOnce.Do(func() {
-			redisCache.redis = &Redis{GroupName: redisCache.GroupName}
+			redisCache.redis = &Redis{GroupName: redisCache.GroupName, App: redisCache.App, ctx: redisCache.ctx}
 		})
 	}
 	return redisCache.redis
@@ -1,6 +1,7 @@
 package frame
 
 import (
+	"context"
 	"strconv"
 	"sync"
 )
@@ -10,12 +11,14 @@ type redisCounter struct {
 	redis     *Redis
 	redisOnce sync.Once
 	GroupName string
+	App       *app            //所属应用,为空使用默认应用
+	ctx       context.Context //报错日志使用的上下文
 }
 
 func (redisCounter *redisCounter) getRedis() *Redis {
 	if redisCounter.redis == nil {
 		redisCounter.redisOnce.Do(func() {
-			redisCounter.redis = &Redis{GroupName: redisCounter.GroupName}
+			redisCounter.redis = &Redis{GroupName: redisCounter.GroupName, App: redisCounter.App, ctx: redisCounter.ctx}
 		})
 	}
 	return redisCounter.redis
@@ -3,7 +3,6 @@ package frame
 import (
 	"github.com/gomodule/redigo/redis"
 	"strconv"
-	"sync"
 	"time"
 )
 
@@ -12,64 +11,120 @@ type redisGroup struct {
 	Slaves []*redis.Pool
 }
 
-var redisGroupCache map[string]*redisGroup
-var redisGroupCacheOnce sync.Once
-var redisLock sync.Mutex
+//配置变更后旧连接池延迟关闭的时间,保证正在执行的请求正常结束
+const poolCloseDelay = 30 * time.Second
 
 type redisHost struct {
-	Master *redisHostConfig   `toml:"master"`
-	Slaves []*redisHostConfig `toml:"slaves"`
+	Master *redisHostConfig   `toml:"master" validate:"required"`
+	Slaves []*redisHostConfig `toml:"slaves"` //为空时读也使用主库
 }
 
 type redisHostConfig struct {
-	Host            string `toml:"host"`
-	Port            int    `toml:"port"`
+	Host            string `toml:"host" validate:"required"`
+	Port            int    `toml:"port" validate:"required,min=1,max=65535"`
 	Password        string `toml:"password"`
-	Timeout         int    `toml:"timeout"`
-	MaxIdle         int    `toml:"MaxIdle"`
-	MaxActive       int    `toml:"MaxActive"`
-	IdleTimeout     int    `toml:"IdleTimeout"`
-	MaxConnLifetime int    `toml:"MaxConnLifetime"`
+	Timeout         int    `toml:"timeout" validate:"min=0"`
+	MaxIdle         int    `toml:"MaxIdle" validate:"min=0"`
+	MaxActive       int    `toml:"MaxActive" validate:"min=0"`
+	IdleTimeout     int    `toml:"IdleTimeout" validate:"min=0"`
+	MaxConnLifetime int    `toml:"MaxConnLifetime" validate:"min=0"`
 }
 
 /**
-建立redis连接池
+建立默认应用的redis连接池
 */
 func GetRedis(groupName string) *redisGroup {
-	if redisGroupCache == nil {
-		redisGroupCacheOnce.Do(func() {
-			redisGroupCache = make(map[string]*redisGroup)
-		})
-	}
-	cache, ok := redisGroupCache[groupName]
+	return App().GetRedis(groupName)
+}
+
+//建立redis连接池
+func (app *app) GetRedis(groupName string) *redisGroup {
+	res := app.resource
+	res.redisLock.RLock()
+	cache, ok := res.redisGroupCache[groupName]
+	res.redisLock.RUnlock()
 	if ok {
 		return cache
 	}
-	redisLock.Lock()
-	defer redisLock.Unlock()
-	redisConfig := &redisHost{}
-	err := App().Env(groupName, redisConfig)
+	res.redisLock.Lock()
+	defer res.redisLock.Unlock()
+	if cache, ok := res.redisGroupCache[groupName]; ok {
+		return cache
+	}
+	//先记录文件状态再读取配置,读取期间的修改不会被漏掉
+	fileStat := app.configFileStat(groupName)
+	group, err := app.newRedisGroup(groupName)
 	if err != nil {
 		panic(RedisConfigError.Error() + ":" + err.Error())
 	}
-	defer func() {
-		redisError(err)
-	}()
-	masterConfig := redisConfig.Master
-	masterPool := &redis.Pool{
-		MaxIdle:     masterConfig.MaxIdle,
-		MaxActive:   masterConfig.MaxActive,
-		IdleTimeout: time.Duration(masterConfig.IdleTimeout) * time.Second,
-		Wait:        true, //超过最大连接数就阻塞等待
-		MaxConnLifetime: time.Duration(masterConfig.MaxConnLifetime) * time.Second, //连接生命周期
+	res.redisGroupCache[groupName] = group
+	//配置文件变化后重建连接池
+	app.watchEnv(groupName, fileStat, func(configFile string) {
+		_ = app.ReloadRedis(configFile)
+	})
+	return group
+}
+
+/**
+重新建立默认应用的redis连接池
+新的请求使用新的连接池,旧连接池延迟关闭
+*/
+func ReloadRedis(groupName string) error {
+	return App().ReloadRedis(groupName)
+}
+
+//重新建立redis连接池
+func (app *app) ReloadRedis(groupName string) error {
+	group, err := app.newRedisGroup(groupName)
+	if err != nil {
+		msg := map[string]interface{}{
+			"group": groupName,
+			"error": err.Error(),
+		}
+		app.Log.errorStack(msg, LogRedisError)
+		return err
+	}
+	res := app.resource
+	res.redisLock.Lock()
+	old, ok := res.redisGroupCache[groupName]
+	res.redisGroupCache[groupName] = group
+	res.redisLock.Unlock()
+	if ok {
+		time.AfterFunc(poolCloseDelay, func() {
+			old.close()
+		})
+	}
+	return nil
+}
+
+func (app *app) newRedisGroup(groupName string) (*redisGroup, error) {
+	redisConfig := &redisHost{}
+	err := app.Env(groupName, redisConfig)
+	if err != nil {
+		return nil, err
+	}
+	slavesPool := make([]*redis.Pool, 0)
+	for _, slaveConfig := range redisConfig.Slaves {
+		slavesPool = append(slavesPool, newRedisPool(slaveConfig))
+	}
+	return &redisGroup{Master: newRedisPool(redisConfig.Master), Slaves: slavesPool}, nil
+}
+
+func newRedisPool(hostConfig *redisHostConfig) *redis.Pool {
+	return &redis.Pool{
+		MaxIdle:         hostConfig.MaxIdle,
+		MaxActive:       hostConfig.MaxActive,
+		IdleTimeout:     time.Duration(hostConfig.IdleTimeout) * time.Second,
+		Wait:            true,                                                    //超过最大连接数就阻塞等待
+		MaxConnLifetime: time.Duration(hostConfig.MaxConnLifetime) * time.Second, //连接生命周期
 		Dial: func() (redis.Conn, error) {
-			c, err := redis.Dial("tcp", masterConfig.Host+":"+
-				strconv.Itoa(masterConfig.Port),
-				redis.DialPassword(masterConfig.Password),
+			c, err := redis.Dial("tcp", hostConfig.Host+":"+
+				strconv.Itoa(hostConfig.Port),
+				redis.DialPassword(hostConfig.Password),
 				redis.DialDatabase(0),
-				redis.DialConnectTimeout(time.Duration(masterConfig.Timeout)*time.Second),
-				redis.DialReadTimeout(time.Duration(masterConfig.Timeout)*time.Second),
-				redis.DialWriteTimeout(time.Duration(masterConfig.Timeout)*time.Second))
+				redis.DialConnectTimeout(time.Duration(hostConfig.Timeout)*time.Second),
+				redis.DialReadTimeout(time.Duration(hostConfig.Timeout)*time.Second),
+				redis.DialWriteTimeout(time.Duration(hostConfig.Timeout)*time.Second))
 			if err != nil {
 				if c != nil {
 					c.Close()
@@ -79,46 +134,19 @@ func GetRedis(groupName string) *redisGroup {
 			return c, err
 		},
 	}
-	slavesConfig := redisConfig.Slaves
-	slavesPool := make([]*redis.Pool, 0)
-	for _, slaveConfig := range slavesConfig {
-		slave := &redis.Pool{
-			MaxIdle:     slaveConfig.MaxIdle,
-			MaxActive:   slaveConfig.MaxActive,
-			IdleTimeout: time.Duration(slaveConfig.IdleTimeout) * time.Second,
-			Wait:        true, //超过最大连接数就阻塞等待
-			MaxConnLifetime: time.Duration(slaveConfig.MaxConnLifetime) * time.Second, //连接生命周期
-			Dial: func() (redis.Conn, error) {
-				c, err := redis.Dial("tcp", slaveConfig.Host+":"+
-					strconv.Itoa(slaveConfig.Port),
-					redis.DialPassword(slaveConfig.Password),
-					redis.DialDatabase(0),
-					redis.DialConnectTimeout(time.Duration(slaveConfig.Timeout)*time.Second),
-					redis.DialReadTimeout(time.Duration(slaveConfig.Timeout)*time.Second),
-					redis.DialWriteTimeout(time.Duration(slaveConfig.Timeout)*time.Second))
-				if err != nil {
-					if c != nil {
-						c.Close()
-					}
-					return nil, err
-				}
-				return c, err
-			},
-		}
-		slavesPool = append(slavesPool, slave)
-	}
-	redisGroupCache[groupName] = &redisGroup{Master: masterPool, Slaves: slavesPool}
-	return redisGroupCache[groupName]
 }
 
-func closeRedis() {
-	if redisGroupCache == nil {
-		return
+func (group *redisGroup) close() {
+	_ = group.Master.Close()
+	for _, v := range group.Slaves {
+		_ = v.Close()
 	}
-	for _, cache := range redisGroupCache {
-		_ = cache.Master.Close()
-		for _, v := range cache.Slaves {
-			_ = v.Close()
-		}
+}
+
+func (app *app) closeRedis() {
+	app.resource.redisLock.RLock()
+	defer app.resource.redisLock.RUnlock()
+	for _, cache := range app.resource.redisGroupCache {
+		cache.close()
 	}
 }
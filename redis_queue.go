@@ -9,12 +9,13 @@ type redisQueue struct {
 	redis     *Redis
 	redisOnce sync.Once
 	GroupName string
+	App       *app //所属应用,为空使用默认应用
 }
 
 func (redisQueue *redisQueue) getRedis() *Redis {
 	if redisQueue.redis == nil {
 		redisQueue.redisOnce.Do(func() {
-			redisQueue.redis = &Redis{GroupName: redisQueue.GroupName}
+			redisQueue.redis = &Redis{GroupName: redisQueue.GroupName, App: redisQueue.App}
 		})
 	}
 	return redisQueue.redis
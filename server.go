@@ -13,7 +13,6 @@ import (
 	"os/signal"
 	"reflect"
 	"runtime"
-	"runtime/debug"
 	"strconv"
 	"strings"
 	"syscall"
@@ -22,10 +21,13 @@ import (
 
 //web服务器结构体
 type server struct {
-	port       int //监听端口
+	app        *app //所属应用
+	port       int  //监听端口
 	httpServer *http.Server
 	router     []func(gin *gin.Engine)
 	pidFile    string
+	done       chan bool //关闭钩子执行完后关闭
+	stopFlag   chan bool //收到退出信号
 }
 
 //启动
@@ -35,6 +37,13 @@ func (server *server) Start() {
 	//记录pid
 	oriPid := server.getPid() //获取原始pid
 	server.logPid()
+	//启动钩子
+	if err := server.app.Start(); err != nil {
+		server.logPid(oriPid)
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	server.done = make(chan bool)
 	go func() {
 		server.shutdown()
 	}()
@@ -53,6 +62,8 @@ func (server *server) Start() {
 		fmt.Println(err)
 		os.Exit(1)
 	}
+	//Shutdown 开始后 ListenAndServe 立即返回,等待关闭钩子执行完(包括日志刷盘)再返回
+	<-server.done
 }
 
 //重启
@@ -84,11 +95,30 @@ func (server *server) Stop(restart ...bool) {
 	}
 }
 
-//信号监听
-var stopFlag chan bool
+//运行状态,返回pid文件中的进程id和进程是否存在
+func (server *server) Status() (int, bool) {
+	pid := server.getPid()
+	if pid == -1 {
+		return pid, false
+	}
+	return pid, syscall.Kill(pid, 0) == nil
+}
 
+//通知运行中的进程重新加载配置文件
+func (server *server) Reload() error {
+	pid, running := server.Status()
+	if !running {
+		return ServerNotRunningError
+	}
+	return syscall.Kill(pid, ReloadSignal)
+}
+
+//重新加载配置的信号
+const ReloadSignal = syscall.SIGUSR1
+
+//信号监听
 func (server *server) registerSignal() {
-	stopFlag = make(chan bool, 0)
+	server.stopFlag = make(chan bool, 0)
 	go func() {
 		listenSignal := []os.Signal{
 			syscall.SIGINT,
@@ -102,18 +132,27 @@ func (server *server) registerSignal() {
 		<-sig
 		server.stop()
 	}()
+	//收到重新加载信号检查配置文件变化
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, ReloadSignal)
+		for range sig {
+			server.app.ReloadEnv()
+		}
+	}()
 }
 
 func (server *server) stop() {
-	stopFlag <- true
+	server.stopFlag <- true
 }
 
 //关闭
 func (server *server) shutdown() {
-	<-stopFlag
+	<-server.stopFlag
+	defer close(server.done)
 	defer func() {
-		//关闭系统资源
-		CloseResource()
+		//执行关闭钩子,包括关闭系统资源
+		_ = server.app.Shutdown()
 	}()
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -121,7 +160,7 @@ func (server *server) shutdown() {
 	if err != nil {
 		//关闭报错
 		defer func() {
-			serverError(err)
+			server.serverError(err)
 		}()
 	}
 }
@@ -137,7 +176,7 @@ func (server *server) kill() {
 	err := proc.Signal(sig)
 	if err != nil {
 		defer func() {
-			serverError(err)
+			server.serverError(err)
 		}()
 	}
 	return
@@ -151,7 +190,7 @@ func (server *server) logPid(setPid ...int) {
 	}
 	file, err := os.OpenFile(server.pidFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0777)
 	defer func() {
-		serverError(err)
+		server.serverError(err)
 	}()
 	if err != nil {
 		return
@@ -169,7 +208,7 @@ func (server *server) logPid(setPid ...int) {
 func (server *server) getPid() int {
 	file, err := os.Open(server.pidFile)
 	defer func() {
-		serverError(err)
+		server.serverError(err)
 	}()
 	if err != nil {
 		return -1
@@ -202,13 +241,12 @@ func (server *server) RegisterRoute(f func(gin *gin.Engine)) *server {
 	return server
 }
 
-func serverError(err error) {
+func (server *server) serverError(err error) {
 	if err != nil {
 		msg := map[string]interface{}{
 			"error": err.Error(),
-			"stack": string(debug.Stack()),
 		}
-		App().Log.Error(msg, LogServerError)
+		server.app.Log.errorStack(msg, LogServerError)
 	}
 }
 
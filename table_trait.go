@@ -8,6 +8,7 @@ type TableTrait struct {
 	Table           string //数据表
 	IsAutoIncrement bool   //是否自增,默认自增
 	PrimaryKey      string //主键,默认id
+	App             *app   //所属应用,为空使用默认应用
 	dbInstance      Db
 }
 
@@ -23,7 +24,7 @@ func (tableTrait *TableTrait) Db() Db {
 			tableTrait.DbType = "mysql"
 		}
 		if tableTrait.DbType == "mysql" {
-			tableTrait.dbInstance = GetMysql(tableTrait.DbGroup)
+			tableTrait.dbInstance = getApp(tableTrait.App).GetMysql(tableTrait.DbGroup)
 		} else {
 			panic(DbAllowError.Error() + ":" + tableTrait.DbType)
 		}
@@ -93,15 +93,13 @@ func ExecShellAsync(s string) error {
 
 
 /**
-关闭系统所用各种资源
+关闭默认应用所用各种资源
 */
 func CloseResource() {
-	//默认数据库资源关闭
-	closeDB()
-	//关闭client中所有闲置连接池
-	closeHttpClient()
-	//关闭memcached中闲置连接
-	closeMemcachedPool()
-	//关闭redis中闲置连接
-	closeRedis()
+	App().CloseResource()
+}
+
+//关闭应用所用各种资源,和 Shutdown 相同,依次执行所有关闭钩子(日志刷盘等),连接池的关闭也在其中
+func (app *app) CloseResource() {
+	_ = app.Shutdown()
 }